@@ -1,22 +1,41 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/elotl/itzo-launcher/pkg/addons"
+	"github.com/elotl/itzo-launcher/pkg/cellconfig"
 	"github.com/elotl/itzo-launcher/pkg/cloudinit"
+	"github.com/elotl/itzo-launcher/pkg/configstore"
+	"github.com/elotl/itzo-launcher/pkg/configwatch"
+	"github.com/elotl/itzo-launcher/pkg/health"
+	"github.com/elotl/itzo-launcher/pkg/logging"
+	"github.com/elotl/itzo-launcher/pkg/logrotate"
+	"github.com/elotl/itzo-launcher/pkg/metrics"
+	"github.com/elotl/itzo-launcher/pkg/parameters"
 	"github.com/elotl/itzo-launcher/pkg/parameters/aws"
+	"github.com/elotl/itzo-launcher/pkg/parameters/azure"
+	"github.com/elotl/itzo-launcher/pkg/parameters/gcp"
+	"github.com/elotl/itzo-launcher/pkg/signature"
+	"github.com/elotl/itzo-launcher/pkg/status"
+	"github.com/elotl/itzo-launcher/pkg/supervisor"
 	"github.com/elotl/itzo-launcher/pkg/util"
-	"github.com/go-yaml/yaml"
 	"github.com/hashicorp/go-multierror"
 	"k8s.io/klog"
 )
@@ -26,21 +45,75 @@ const (
 	ItzoDefaultPath           = "/usr/local/bin/itzo"
 	ItzoURLFile               = ItzoDir + "/itzo_url"
 	ItzoVersionFile           = ItzoDir + "/itzo_version"
+	ItzoSHA256File            = ItzoDir + "/itzo_sha256"
 	CellConfigFile            = ItzoDir + "/cell_config.yaml"
 	InstanceParameterBasePath = "/kip/cells"
 	ItzoDefaultURL            = "https://itzo-kip-download.s3.amazonaws.com"
 	ItzoDefaultVersionAMD64   = "latest"
 	ItzoDefaultVersionARM64   = "arm-latest"
+	ItzoDefaultChannel        = "latest"
+	ItzoChannelManifest       = "channels.json"
 )
 
 var (
 	BuildVersion = "N/A"
 	BuildTime    = "N/A"
+	// ItzoPublicKey is the minisign public key used for verifying itzo
+	// downloads. It is set at build time.
+	ItzoPublicKey = ""
+	// launcherStatus records the phases of starting up.
+	launcherStatus = status.New("", BuildVersion)
+	// configMu serializes applying fetched configs, since fetches that
+	// finish in the background, e.g. from PollConfig, can overlap.
+	configMu sync.Mutex
 )
 
 var (
-	version    = flag.Bool("version", false, "print version and exit")
-	itzoLogDir = flag.String("itzo-log-dir", "/var/log/itzo", "directory for itzo.log")
+	version     = flag.Bool("version", false, "print version and exit")
+	statusFile  = flag.String("status-file", "/var/run/itzo-launcher/status.json", "file the launcher records the phases of starting up in; empty disables it")
+	metricsAddr = flag.String("metrics-addr", "", "address for serving Prometheus metrics on /metrics, e.g. :9090; empty disables it")
+	logFormat   = flag.String("log-format", string(logging.FormatText), "format of log lines: text or json; in json mode the output of itzo is also wrapped in JSON records")
+	itzoLogDir  = flag.String("itzo-log-dir", "/var/log/itzo", "directory for itzo.log")
+
+	itzoLogMaxSize    = flag.Int("itzo-log-max-size", 100, "rotate itzo.log once it reaches this many megabytes; 0 means no limit (cell config: itzoLogMaxSize)")
+	itzoLogMaxAge     = flag.Duration("itzo-log-max-age", 24*time.Hour, "rotate itzo.log once it gets this old; 0 means no limit (cell config: itzoLogMaxAge)")
+	itzoLogMaxBackups = flag.Int("itzo-log-max-backups", 5, "number of rotated itzo logs to keep; 0 means keep all of them (cell config: itzoLogMaxBackups)")
+	itzoLogCompress   = flag.Bool("itzo-log-compress", true, "compress rotated itzo logs with gzip (cell config: itzoLogCompress)")
+
+	itzoPublicKeyFile = flag.String("itzo-public-key", "", "path to a minisign public key file for verifying itzo downloads; overrides the built-in key")
+	skipItzoSignature = flag.Bool("insecure-skip-itzo-signature", false, "do not verify the signature of itzo downloads; only use this on dev clusters")
+
+	itzoRestartPolicy     = flag.String("itzo-restart-policy", string(supervisor.RestartAlways), "when to restart itzo after it exits: always, on-failure or never")
+	itzoRestartBackoff    = flag.Duration("itzo-restart-backoff", 1*time.Second, "delay before restarting itzo, doubled after each restart")
+	itzoRestartBackoffMax = flag.Duration("itzo-restart-backoff-max", 1*time.Minute, "maximum delay before restarting itzo")
+	itzoMaxFailures       = flag.Int("itzo-max-failures", 5, "give up if itzo fails this many times within --itzo-failure-window; 0 means never give up")
+	itzoFailureWindow     = flag.Duration("itzo-failure-window", 10*time.Minute, "time window for counting itzo failures")
+	itzoGracePeriod       = flag.Duration("itzo-grace-period", 30*time.Second, "time itzo has to shut down after SIGTERM or SIGINT before it gets killed")
+
+	itzoHealthURL              = flag.String("itzo-health-url", "https://127.0.0.1:6421/rest/v1/ping", "itzo endpoint probed for readiness; any response other than a 5xx status counts as healthy; empty disables probing")
+	itzoHealthInterval         = flag.Duration("itzo-health-interval", 10*time.Second, "time between probes of itzo")
+	itzoHealthTimeout          = flag.Duration("itzo-health-timeout", 5*time.Second, "timeout for probing itzo")
+	itzoHealthStartTimeout     = flag.Duration("itzo-health-start-timeout", 2*time.Minute, "time itzo has to answer probes after it's started")
+	itzoHealthFailureThreshold = flag.Int("itzo-health-failure-threshold", 3, "restart itzo after it failed this many probes in a row; 0 means never")
+	healthAddr                 = flag.String("health-addr", "127.0.0.1:6422", "address for serving /healthz and /readyz; empty disables it")
+
+	stateDir           = flag.String("state-dir", "/var/lib/itzo-launcher/state", "directory for keeping the last fetched config across reboots; empty disables it")
+	configTTL          = flag.Duration("config-ttl", 24*time.Hour, "use the saved config right away if it's younger than this, and refresh it in the background")
+	configFetchTimeout = flag.Duration("config-fetch-timeout", 30*time.Second, "if the saved config is older than --config-ttl, wait this long for fetching the config before using the saved one; 0 waits until the fetch is done")
+
+	cellConfigWatchInterval = flag.Duration("cell-config-watch-interval", 10*time.Second, "check cell_config.yaml for changes this often, re-running the addons and restarting itzo if their keys changed; 0 disables it")
+	configPollInterval      = flag.Duration("config-poll-interval", 0, "fetch the config from instance parameters or user data again this often; 0 disables it")
+
+	cacheDir        = flag.String("cache-dir", "/var/lib/itzo-launcher/cache", "directory for caching downloaded itzo binaries")
+	denylistFile    = flag.String("itzo-denylist", "/var/lib/itzo-launcher/itzo-denylist", "file recording itzo binaries that failed to start")
+	itzoMinUptime   = flag.Duration("itzo-min-uptime", 30*time.Second, "itzo needs to run this long to be considered started; a newly installed itzo exiting sooner is rolled back to the previous version")
+	downloadRetries = flag.Int("download-retries", util.DownloadRetries, "number of times to retry failed downloads")
+	downloadTimeout = flag.Duration("download-timeout", util.DownloadTimeout, "overall deadline for a download, including retries")
+
+	downloadProxy      = flag.String("download-proxy", "", "proxy URL for downloads; by default HTTPS_PROXY, HTTP_PROXY and NO_PROXY are used (cell config: downloadProxy)")
+	downloadCABundle   = flag.String("download-ca-bundle", "", "PEM file with additional CA certificates for downloads (cell config: downloadCABundle)")
+	downloadClientCert = flag.String("download-client-cert", "", "PEM file with a client certificate for downloads (cell config: downloadClientCert)")
+	downloadClientKey  = flag.String("download-client-key", "", "PEM file with the key of the client certificate for downloads (cell config: downloadClientKey)")
 )
 
 func getItzoURL() (string, error) {
@@ -58,6 +131,21 @@ func getItzoURL() (string, error) {
 	return itzoURL, nil
 }
 
+func isOCIURL(itzoURL string) bool {
+	return strings.HasPrefix(itzoURL, "oci://") || strings.HasPrefix(itzoURL, "oci+http://")
+}
+
+// getItzoDownloadURL returns the location of the requested itzo version. In OCI
+// registries, versions are tags of the itzo repository; everywhere else, they
+// are files called itzo-<version>.
+func getItzoDownloadURL(itzoURL, itzoVersion string) string {
+	itzoURL = strings.TrimRight(itzoURL, "/")
+	if isOCIURL(itzoURL) {
+		return fmt.Sprintf("%s:%s", itzoURL, itzoVersion)
+	}
+	return fmt.Sprintf("%s/itzo-%s", itzoURL, itzoVersion)
+}
+
 func getItzoDefaultVersion() string {
 	switch runtime.GOARCH {
 	case "arm64":
@@ -88,40 +176,570 @@ func getItzoVersion() (string, error) {
 	return itzoVersion, nil
 }
 
-func ProcessInstanceParameters() error {
+// getItzoDigest returns the expected SHA-256 digest of the itzo binary. It is
+// either supplied via ItzoSHA256File, taken from the channel manifest, or
+// fetched from a companion .sha256 file next to the binary. An empty digest
+// means no digest is available.
+func getItzoDigest(release *itzoRelease) (string, error) {
+	contents, err := ioutil.ReadFile(ItzoSHA256File)
+	if err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("reading %s: %v", ItzoSHA256File, err)
+		klog.Errorf("%v", err)
+		return "", err
+	} else if err == nil && strings.TrimSpace(string(contents)) != "" {
+		digest, err := util.ParseDigest(string(contents))
+		if err != nil {
+			return "", fmt.Errorf("parsing %s: %v", ItzoSHA256File, err)
+		}
+		return digest, nil
+	}
+	if release.digest != "" {
+		return release.digest, nil
+	}
+	digestURL := release.url + ".sha256"
+	digest, err := util.FetchDigest(digestURL)
+	if err != nil {
+		return "", err
+	}
+	if digest == "" {
+		klog.Warningf("no SHA-256 digest available for %s", release.url)
+	}
+	return digest, nil
+}
+
+func getItzoPublicKey() (*signature.PublicKey, error) {
+	keyData := ItzoPublicKey
+	if *itzoPublicKeyFile != "" {
+		contents, err := ioutil.ReadFile(*itzoPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", *itzoPublicKeyFile, err)
+		}
+		keyData = string(contents)
+	}
+	if keyData == "" {
+		return nil, fmt.Errorf("no public key configured for verifying itzo; build the launcher with make ITZO_PUBLIC_KEY=<key>, or pass --itzo-public-key")
+	}
+	return signature.ParsePublicKey(keyData)
+}
+
+// getItzoVerifier returns a function that checks the detached minisign
+// signature of a downloaded itzo binary.
+func getItzoVerifier(itzoDownloadURL string) (func(string) error, error) {
+	if *skipItzoSignature {
+		klog.Warningf("signature verification of itzo is disabled")
+		return nil, nil
+	}
+	publicKey, err := getItzoPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	signatureURL := itzoDownloadURL + ".minisig"
+	contents, err := util.FetchSmallFile(signatureURL, 4096)
+	if err != nil {
+		return nil, err
+	}
+	if contents == nil {
+		return nil, fmt.Errorf("no signature found at %s", signatureURL)
+	}
+	sig, err := signature.ParseSignature(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", signatureURL, err)
+	}
+	return func(path string) error {
+		err := publicKey.VerifyFile(path, sig)
+		if err != nil {
+			return err
+		}
+		klog.V(2).Infof("verified signature of %s (%s)", path, sig.TrustedComment())
+		return nil
+	}, nil
+}
+
+// ProcessInstanceParameters writes the config files from the instance
+// parameters into dir.
+func ProcessInstanceParameters(dir string) error {
 	klog.V(2).Infof("checking instance parameters")
-	// For now, only AWS is supported. On other platforms we'll fall back to
-	// cloud-init user data.
-	params, err := aws.NewAWSParameters(InstanceParameterBasePath, nil)
+	// Providers are tried in order. If none of them is detected, we'll fall
+	// back to cloud-init user data.
+	provider, err := parameters.Detect(
+		aws.NewAWSParameters(InstanceParameterBasePath, nil),
+		gcp.NewGCPParameters(),
+		azure.NewAzureParameters(),
+	)
 	if err != nil {
 		return err
 	}
-	allParameters, err := params.GetAllParameters()
+	allParameters, err := provider.GetAllParameters()
 	if err != nil {
-		return fmt.Errorf("getting instance parameters: %v", err)
+		return fmt.Errorf("getting instance parameters from %s: %v", provider.Name(), err)
 	}
-	err = os.MkdirAll(ItzoDir, 0755)
+	err = os.MkdirAll(dir, 0755)
 	if err != nil {
-		return fmt.Errorf("ensuring %s exists: %v", ItzoDir, err)
+		return fmt.Errorf("ensuring %s exists: %v", dir, err)
 	}
 	for name, value := range allParameters {
-		ppath := filepath.Join(ItzoDir, name)
+		ppath := filepath.Join(dir, name)
 		err = ioutil.WriteFile(ppath, []byte(value), 0600)
 		if err != nil {
 			return fmt.Errorf("writing instance parameter %s to %s: %v", name, ppath, err)
 		}
 	}
 	klog.V(2).Infof("retrieved %d instance parameters", len(allParameters))
+	metrics.SetParameterSource(provider.Name())
 	return nil
 }
 
-func ProcessUserData() error {
+// configFileNames returns the names of the config files the launcher reads
+// from ItzoDir. When they are missing from a fetched config, they are removed
+// from ItzoDir, so nothing from an older config is left behind.
+func configFileNames() []string {
+	return []string{
+		filepath.Base(ItzoURLFile),
+		filepath.Base(ItzoVersionFile),
+		filepath.Base(ItzoSHA256File),
+		filepath.Base(CellConfigFile),
+	}
+}
+
+// fetchConfig fetches the config files into dir, from the instance parameters
+// or, if there are none, from the user data. It returns where the config came
+// from. Phases are only recorded if it's not running in the background.
+func fetchConfig(dir string, background bool) (string, error) {
+	run := runPhase
+	if background {
+		run = func(name string, fn func() error) error {
+			return fn()
+		}
+	}
+	err := run("instance-parameters", func() error {
+		return ProcessInstanceParameters(dir)
+	})
+	if err == nil {
+		return "instance-parameters", nil
+	}
+	klog.Warningf("failed to process instance parameters: %v, falling back to user-data", err)
+	err = run("user-data", func() error {
+		return ProcessUserData(dir)
+	})
+	if err != nil {
+		return "", fmt.Errorf("processing cloud-init user data: %v", err)
+	}
+	return "user-data", nil
+}
+
+// stagedConfig is a config fetched into a staging directory.
+type stagedConfig struct {
+	dir    string
+	source string
+	err    error
+}
+
+// stageConfig fetches the config into a new staging directory. Unless the
+// fetch fails, the caller has to remove the directory.
+func stageConfig(background bool) stagedConfig {
+	staging, err := ioutil.TempDir("", "itzo-config")
+	if err != nil {
+		return stagedConfig{err: fmt.Errorf("creating staging directory: %v", err)}
+	}
+	source, err := fetchConfig(staging, background)
+	if err != nil {
+		os.RemoveAll(staging)
+		return stagedConfig{err: err}
+	}
+	return stagedConfig{dir: staging, source: source}
+}
+
+// applyConfig syncs a staged config into ItzoDir, where changes to the cell
+// config are picked up by the watcher. It is also saved for the next boot,
+// unless store is nil.
+func applyConfig(store *configstore.Store, staged stagedConfig) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+	_, err := configstore.SyncFiles(staged.dir, ItzoDir, configFileNames()...)
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return nil
+	}
+	changed, err := store.Save(staged.dir, staged.source)
+	if err != nil {
+		klog.Warningf("saving config: %v", err)
+	} else if changed {
+		klog.Infof("config from %s changed", staged.source)
+	} else {
+		klog.V(2).Infof("config from %s is unchanged", staged.source)
+	}
+	return nil
+}
+
+// LoadConfig puts the config files into ItzoDir. A saved config that is
+// younger than --config-ttl is used right away and refreshed in the
+// background. Otherwise the config is fetched, and the saved one is used if
+// that fails, or doesn't finish within --config-fetch-timeout; in the latter
+// case the fetched config is applied once it's ready.
+func LoadConfig() error {
+	var store *configstore.Store
+	var snapshot *configstore.Snapshot
+	if *stateDir != "" {
+		store = configstore.New(*stateDir)
+		var err error
+		snapshot, err = store.Load()
+		if err != nil {
+			klog.Warningf("loading saved config: %v", err)
+			snapshot = nil
+		}
+	}
+	if snapshot != nil && snapshot.Age() < *configTTL {
+		err := runPhase("saved-config", func() error {
+			_, err := store.Restore(ItzoDir, configFileNames()...)
+			return err
+		})
+		if err == nil {
+			klog.Infof("using config from %s saved %v ago, refreshing it in the background",
+				snapshot.Source, snapshot.Age().Round(time.Second))
+			go refreshConfig(store)
+			return nil
+		}
+		klog.Warningf("restoring saved config: %v", err)
+		snapshot = nil
+	}
+	if snapshot == nil {
+		staged := stageConfig(false)
+		if staged.err != nil {
+			return staged.err
+		}
+		defer os.RemoveAll(staged.dir)
+		return applyConfig(store, staged)
+	}
+	// The fetch may outlive LoadConfig if it times out, so it runs like a
+	// background one, without recording phases; only waiting for it is
+	// recorded.
+	results := make(chan stagedConfig, 1)
+	go func() {
+		results <- stageConfig(true)
+	}()
+	var timeout <-chan time.Time
+	if *configFetchTimeout > 0 {
+		timeout = time.After(*configFetchTimeout)
+	}
+	var staged stagedConfig
+	timedOut := false
+	_ = runPhase("fetch-config", func() error {
+		select {
+		case staged = <-results:
+			return staged.err
+		case <-timeout:
+			timedOut = true
+			return fmt.Errorf("timed out after %v", *configFetchTimeout)
+		}
+	})
+	if timedOut {
+		klog.Warningf("fetching config takes longer than %v; using config from %s saved %v ago until it's done",
+			*configFetchTimeout, snapshot.Source, snapshot.Age().Round(time.Second))
+		_, err := store.Restore(ItzoDir, configFileNames()...)
+		if err == nil {
+			go func() {
+				refreshed := <-results
+				if refreshed.err != nil {
+					klog.Warningf("fetching config: %v; keeping saved config", refreshed.err)
+					return
+				}
+				defer os.RemoveAll(refreshed.dir)
+				err := applyConfig(store, refreshed)
+				if err != nil {
+					klog.Warningf("applying fetched config: %v", err)
+				}
+			}()
+			return nil
+		}
+		klog.Warningf("restoring saved config: %v; waiting for the fetch", err)
+		staged = <-results
+		if staged.err != nil {
+			return staged.err
+		}
+	} else if staged.err != nil {
+		klog.Warningf("fetching config: %v; falling back to config from %s saved %v ago",
+			staged.err, snapshot.Source, snapshot.Age().Round(time.Second))
+		_, err := store.Restore(ItzoDir, configFileNames()...)
+		if err != nil {
+			return fmt.Errorf("%v; restoring saved config: %v", staged.err, err)
+		}
+		return nil
+	}
+	defer os.RemoveAll(staged.dir)
+	return applyConfig(store, staged)
+}
+
+// refreshConfig fetches the config in the background, and applies it. It is
+// also saved for the next boot, unless store is nil.
+func refreshConfig(store *configstore.Store) {
+	staged := stageConfig(true)
+	if staged.err != nil {
+		klog.Warningf("refreshing config: %v; keeping current config", staged.err)
+		return
+	}
+	defer os.RemoveAll(staged.dir)
+	err := applyConfig(store, staged)
+	if err != nil {
+		klog.Warningf("refreshing config: %v", err)
+	}
+}
+
+// PollConfig fetches the config every interval until ctx is done.
+func PollConfig(ctx context.Context, interval time.Duration) {
+	var store *configstore.Store
+	if *stateDir != "" {
+		store = configstore.New(*stateDir)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		klog.V(2).Infof("polling config")
+		refreshConfig(store)
+	}
+}
+
+// ProcessUserData writes the config files from the cloud-init user data into
+// dir.
+func ProcessUserData(dir string) error {
 	klog.V(2).Infof("getting itzo files from cloud-init")
-	err := cloudinit.WriteFiles(ItzoURLFile, ItzoVersionFile, CellConfigFile)
+	source, err := cloudinit.WriteFiles(dir, ItzoURLFile, ItzoVersionFile, ItzoSHA256File, CellConfigFile)
 	if err != nil {
 		return err
 	}
 	klog.V(2).Infof("wrote itzo files from cloud-init")
+	metrics.SetParameterSource(source)
+	return nil
+}
+
+// itzoRelease is the itzo binary that needs to be installed.
+type itzoRelease struct {
+	// version is a concrete version, or a name like "latest".
+	version string
+	url     string
+	// digest is the expected SHA-256 digest, if it's known upfront.
+	digest string
+	// constraint is checked against the installed itzo. If it matches, the
+	// installed binary is used instead of downloading url.
+	constraint string
+}
+
+// fetchItzoChannelManifest returns the channel manifest next to the itzo
+// binaries at itzoURL, or nil if there is none. Tags already serve as channels
+// in OCI registries, so they have no manifest.
+func fetchItzoChannelManifest(itzoURL string) (*util.ChannelManifest, error) {
+	if isOCIURL(itzoURL) {
+		return nil, nil
+	}
+	manifestURL := strings.TrimRight(itzoURL, "/") + "/" + ItzoChannelManifest
+	manifest, err := util.FetchChannelManifest(manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		klog.V(2).Infof("no channel manifest found at %s", manifestURL)
+	}
+	return manifest, nil
+}
+
+// resolveItzoChannel looks up itzoVersion in the channel manifest of
+// itzoURL. The default version is looked up as the ItzoDefaultChannel. If
+// there is no manifest, or itzoVersion is not a channel, nil is returned.
+func resolveItzoChannel(itzoURL, itzoVersion string) (*itzoRelease, error) {
+	channel := itzoVersion
+	if itzoVersion == getItzoDefaultVersion() {
+		channel = ItzoDefaultChannel
+	}
+	manifest, err := fetchItzoChannelManifest(itzoURL)
+	if err != nil || manifest == nil {
+		return nil, err
+	}
+	release, err := manifest.Resolve(channel, runtime.GOARCH, strings.TrimRight(itzoURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if release == nil {
+		klog.V(2).Infof("channel %q for %s not found in channel manifest",
+			channel, runtime.GOARCH)
+		return nil, nil
+	}
+	klog.Infof("itzo channel %q resolved to version %q", channel, release.Version)
+	return &itzoRelease{
+		version:    release.Version,
+		url:        release.URL,
+		digest:     release.SHA256,
+		constraint: "=" + release.Version,
+	}, nil
+}
+
+// resolveItzoConstraint looks up the newest release in the channel manifest
+// that satisfies constraint. It returns nil if there is none.
+func resolveItzoConstraint(itzoURL string, constraint *util.Constraint) (*itzoRelease, error) {
+	manifest, err := fetchItzoChannelManifest(itzoURL)
+	if err != nil || manifest == nil {
+		return nil, err
+	}
+	release, err := manifest.ResolveConstraint(constraint, runtime.GOARCH, strings.TrimRight(itzoURL, "/"))
+	if err != nil || release == nil {
+		return nil, err
+	}
+	klog.Infof("itzo version %q resolved to version %q of channel %q",
+		constraint, release.Version, release.Channel)
+	return &itzoRelease{
+		version:    release.Version,
+		url:        release.URL,
+		digest:     release.SHA256,
+		constraint: constraint.String(),
+	}, nil
+}
+
+// resolveItzoRelease determines which itzo binary to install for the
+// requested itzoVersion. Version constraints are resolved to their lower
+// bound, while channels are looked up in the channel manifest. Anything else,
+// e.g. "latest" without a channel manifest, is downloaded as is.
+func resolveItzoRelease(itzoURL, itzoVersion string) (*itzoRelease, error) {
+	constraint, err := util.ParseConstraint(itzoVersion)
+	if err != nil {
+		// A name like "latest" or a release channel.
+		release, err := resolveItzoChannel(itzoURL, itzoVersion)
+		if err != nil || release != nil {
+			return release, err
+		}
+		return &itzoRelease{
+			version: itzoVersion,
+			url:     getItzoDownloadURL(itzoURL, itzoVersion),
+		}, nil
+	}
+	downloadVersion, err := constraint.DownloadVersion()
+	if err == nil {
+		return &itzoRelease{
+			version:    downloadVersion,
+			url:        getItzoDownloadURL(itzoURL, downloadVersion),
+			constraint: itzoVersion,
+		}, nil
+	}
+	// A range like "~1.4" or "<1.5"; look for a release satisfying it.
+	release, channelErr := resolveItzoConstraint(itzoURL, constraint)
+	if channelErr != nil {
+		return nil, fmt.Errorf("%v; looking up release channels: %v", err, channelErr)
+	}
+	if release == nil {
+		return nil, fmt.Errorf("%v, and no release channel satisfies it", err)
+	}
+	return release, nil
+}
+
+// findInstalledItzo returns the path of the installed itzo if its version
+// satisfies constraint, or an empty string.
+func findInstalledItzo(constraint string) (string, error) {
+	installedPath, err := util.FindProg(ItzoDefaultPath, constraint, "--version")
+	if err != nil {
+		return "", err
+	}
+	if installedPath != "" {
+		klog.V(2).Infof("itzo is installed at %s", installedPath)
+	}
+	return installedPath, nil
+}
+
+// downloadItzo downloads, verifies and installs itzo at itzoPath, and adds it
+// to the cache. Nothing is done if the binary at itzoPath is still the same
+// as the remote one.
+func downloadItzo(release *itzoRelease, itzoPath string) error {
+	// Check this first, so the digest and signature are not fetched, and the
+	// binary is not cached again, every time the launcher starts.
+	if util.IsUnchanged(release.url, itzoPath) {
+		klog.V(2).Infof("%s has not changed since it was installed at %s", release.url, itzoPath)
+		return nil
+	}
+	itzoDigest, err := getItzoDigest(release)
+	if err != nil {
+		return fmt.Errorf("getting itzo digest: %v", err)
+	}
+	itzoVerifier, err := getItzoVerifier(release.url)
+	if err != nil {
+		return fmt.Errorf("getting itzo signature: %v", err)
+	}
+	denylist := util.NewDenylist(*denylistFile)
+	opts := util.InstallOptions{
+		Digest: itzoDigest,
+		Verify: func(path string) error {
+			if itzoVerifier != nil {
+				err := itzoVerifier(path)
+				if err != nil {
+					return err
+				}
+			}
+			return denylist.Check(path)
+		},
+		KeepPrevious: true,
+	}
+	err = util.InstallProg(release.url, itzoPath, opts)
+	if err != nil {
+		return err
+	}
+	err = util.NewProgCache(*cacheDir, "itzo").Store(itzoPath, release.version, "--version")
+	if err != nil {
+		klog.Warningf("caching itzo version %q: %v", release.version, err)
+	}
+	return nil
+}
+
+// useCachedItzo installs the cached itzo binary with the highest version that
+// satisfies the itzoVersion constraint at itzoPath. If itzoVersion is not a
+// constraint, e.g. "latest" or a channel, the highest cached version is used.
+func useCachedItzo(itzoVersion, itzoPath string) error {
+	constraint := itzoVersion
+	if _, err := util.ParseConstraint(itzoVersion); err != nil {
+		constraint = ""
+	}
+	cache := util.NewProgCache(*cacheDir, "itzo")
+	cachePath := cache.Find(constraint, "--version")
+	if cachePath == "" {
+		return fmt.Errorf("no cached itzo found for version %q", itzoVersion)
+	}
+	klog.Warningf("using cached itzo %s", cachePath)
+	return cache.Restore(cachePath, itzoPath)
+}
+
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0
+}
+
+// rollbackItzo restores the itzo binary that was installed before itzoPath,
+// and makes sure the bad binary won't be installed again.
+func rollbackItzo(itzoPath string) error {
+	badVersion, err := util.ProgVersion(itzoPath, "--version")
+	if err != nil {
+		badVersion = "unknown"
+	}
+	badDigest, err := util.FileDigest(itzoPath)
+	if err != nil {
+		return err
+	}
+	err = util.NewDenylist(*denylistFile).Add(itzoPath, badVersion)
+	if err != nil {
+		klog.Errorf("adding %s to denylist: %v", itzoPath, err)
+	}
+	err = util.RollbackProg(itzoPath)
+	if err != nil {
+		return err
+	}
+	cache := util.NewProgCache(*cacheDir, "itzo")
+	cache.RemoveDigest(badDigest)
+	if version, err := util.ProgVersion(itzoPath, "--version"); err == nil {
+		err = cache.Store(itzoPath, version, "--version")
+		if err != nil {
+			klog.Warningf("caching itzo version %q: %v", version, err)
+		}
+	}
 	return nil
 }
 
@@ -135,18 +753,45 @@ func EnsureItzo() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	itzoDownloadURL := fmt.Sprintf("%s/itzo-%s", itzoURL, itzoVersion)
 	itzoPath := ItzoDefaultPath
-	if itzoVersion != getItzoDefaultVersion() {
-		itzoPath, err = util.EnsureProg(ItzoDefaultPath, itzoDownloadURL, itzoVersion, "--version")
+	// Check the installed itzo before resolving a release, so it's used even
+	// if no release can be resolved or downloaded.
+	if _, err := util.ParseConstraint(itzoVersion); err == nil {
+		installedPath, err := findInstalledItzo(itzoVersion)
 		if err != nil {
 			klog.Errorf("ensuring itzo version %q: %v", itzoVersion, err)
 			return "", err
 		}
-	} else {
-		err = util.InstallProg(itzoDownloadURL, ItzoDefaultPath)
+		if installedPath != "" {
+			return installedPath, nil
+		}
+	}
+	release, err := resolveItzoRelease(itzoURL, itzoVersion)
+	if err == nil && release.constraint != "" && release.constraint != itzoVersion {
+		// A channel resolved to a concrete version.
+		installedPath, err := findInstalledItzo(release.constraint)
 		if err != nil {
-			klog.Errorf("downloading itzo version %q: %v", itzoVersion, err)
+			klog.Errorf("ensuring itzo version %q: %v", itzoVersion, err)
+			return "", err
+		}
+		if installedPath != "" {
+			return installedPath, nil
+		}
+	}
+	if err == nil {
+		err = downloadItzo(release, itzoPath)
+	}
+	var deniedErr *util.DeniedError
+	if errors.As(err, &deniedErr) && isExecutable(itzoPath) {
+		// This version has been rolled back before, keep using the one that
+		// is installed.
+		klog.Warningf("not installing itzo version %q: %v; using %s",
+			itzoVersion, err, itzoPath)
+	} else if err != nil {
+		klog.Errorf("downloading itzo version %q: %v", itzoVersion, err)
+		cacheErr := useCachedItzo(itzoVersion, itzoPath)
+		if cacheErr != nil {
+			klog.Errorf("falling back to cached itzo: %v", cacheErr)
 			return "", err
 		}
 	}
@@ -154,85 +799,336 @@ func EnsureItzo() (string, error) {
 	return itzoPath, nil
 }
 
-func RunItzo(itzoPath, logDir string) error {
+func RunItzo(s *supervisor.Supervisor, checker *health.Checker, itzoPath, logDir string) error {
 	klog.V(2).Infof("starting itzo")
 
 	config, err := readCellConfig()
 	if err != nil {
 		klog.Warningf("cannot read cell config to get extra itzo flags: %v", err)
+		config = &cellconfig.Config{}
 	}
 	klog.V(5).Info(config)
 
-	logfile, err := os.OpenFile(
-		logDir+"/itzo.log", os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	logfile, err := newItzoLogWriter(filepath.Join(logDir, "itzo.log"), config.Settings)
 	if err != nil {
-		return fmt.Errorf("opening itzo logfile: %v", err)
+		return fmt.Errorf("configuring itzo logfile: %v", err)
 	}
 	defer logfile.Close()
 
-	// here we get itzo flags from cell_config.yaml
-	cmdArgs := util.GetItzoFlags(config)
-	cmd := exec.Command(
-		itzoPath,
-		cmdArgs...,
-	)
-	cmd.Stdout = logfile
-	cmd.Stderr = logfile
-	klog.Infof("running %v", cmd)
-	err = cmd.Run()
+	policy, err := supervisor.ParseRestartPolicy(*itzoRestartPolicy)
 	if err != nil {
-		return fmt.Errorf("running %v: %v", cmd, err)
+		return err
 	}
 
-	klog.Warningf("%v exited", cmd)
+	// here we get itzo flags and environment from cell_config.yaml
+	itzoConfig := config.Itzo
+	s.Policy = policy
+	s.BackoffBase = *itzoRestartBackoff
+	s.BackoffMax = *itzoRestartBackoffMax
+	s.MinUptime = *itzoMinUptime
+	s.MaxFailures = *itzoMaxFailures
+	s.FailureWindow = *itzoFailureWindow
+	var stdout, stderr io.Writer = logfile, logfile
+	if *logFormat == string(logging.FormatJSON) {
+		stdoutWriter := logging.NewStreamWriter(logfile, "stdout")
+		stderrWriter := logging.NewStreamWriter(logfile, "stderr")
+		defer stdoutWriter.Flush()
+		defer stderrWriter.Flush()
+		stdout, stderr = stdoutWriter, stderrWriter
+	}
+	s.Command = func() (*exec.Cmd, error) {
+		// Re-read the flags on every start, so a restart picks up changes to
+		// the cell config. If it can't be read, the previous flags are kept.
+		config, err := readCellConfig()
+		if err == nil {
+			itzoConfig = config.Itzo
+		}
+		cmd := exec.Command(
+			itzoPath,
+			itzoConfig.Args()...,
+		)
+		cmd.Env = append(os.Environ(), itzoConfig.Environ()...)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		metrics.ItzoStarting()
+		checker.Started()
+		return cmd, nil
+	}
+	s.Up = func(cmd *exec.Cmd) {
+		if !util.HasPreviousProg(itzoPath) {
+			return
+		}
+		klog.V(2).Infof("%v is up, removing previous version", cmd)
+		err := util.CommitProg(itzoPath)
+		if err != nil {
+			klog.Warningf("%v", err)
+		}
+	}
+	s.Exited = func(cmd *exec.Cmd, uptime time.Duration, err error) bool {
+		metrics.ItzoExited()
+		checker.Stopped()
+		if uptime >= *itzoMinUptime || !util.HasPreviousProg(itzoPath) {
+			return false
+		}
+		klog.Errorf("newly installed %v exited after less than %v: %v",
+			cmd, *itzoMinUptime, err)
+		rollbackErr := rollbackItzo(itzoPath)
+		if rollbackErr != nil {
+			klog.Errorf("rolling back %s: %v", itzoPath, rollbackErr)
+			return false
+		}
+		return true
+	}
+	err = s.Run()
+	if err != nil {
+		return fmt.Errorf("running %s: %w", itzoPath, err)
+	}
+	klog.Warningf("%s exited", itzoPath)
 	return nil
 }
 
-func readCellConfig() (map[string]string, error) {
-	config := make(map[string]string)
-	contents, err := ioutil.ReadFile(CellConfigFile)
-	if err != nil {
-		klog.Warningf("reading %s: %v", CellConfigFile, err)
-		return nil, err
-	} else {
-		err = yaml.Unmarshal(contents, &config)
+// newItzoLogWriter creates the writer for the itzo log, rotating it according
+// to the --itzo-log-* flags. Settings in the cell config are used for flags not
+// set on the command line.
+func newItzoLogWriter(path string, config map[string]string) (*logrotate.Writer, error) {
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	maxSize := *itzoLogMaxSize
+	maxAge := *itzoLogMaxAge
+	maxBackups := *itzoLogMaxBackups
+	compress := *itzoLogCompress
+	settings := []struct {
+		flag  string
+		key   string
+		parse func(string) error
+	}{
+		{"itzo-log-max-size", "itzoLogMaxSize", func(v string) (err error) {
+			maxSize, err = strconv.Atoi(v)
+			return err
+		}},
+		{"itzo-log-max-age", "itzoLogMaxAge", func(v string) (err error) {
+			maxAge, err = time.ParseDuration(v)
+			return err
+		}},
+		{"itzo-log-max-backups", "itzoLogMaxBackups", func(v string) (err error) {
+			maxBackups, err = strconv.Atoi(v)
+			return err
+		}},
+		{"itzo-log-compress", "itzoLogCompress", func(v string) (err error) {
+			compress, err = strconv.ParseBool(v)
+			return err
+		}},
+	}
+	for _, s := range settings {
+		if setFlags[s.flag] || config[s.key] == "" {
+			continue
+		}
+		klog.V(2).Infof("using %s from cell config", s.key)
+		err := s.parse(config[s.key])
 		if err != nil {
-			klog.Warningf("unmarshaling config %s: %v", contents, err)
-			return nil, err
+			return nil, fmt.Errorf("invalid %s %q in cell config: %v",
+				s.key, config[s.key], err)
 		}
 	}
+	return &logrotate.Writer{
+		Path:       path,
+		MaxSize:    int64(maxSize) * 1024 * 1024,
+		MaxAge:     maxAge,
+		MaxBackups: maxBackups,
+		Compress:   compress,
+	}, nil
+}
+
+func readCellConfig() (*cellconfig.Config, error) {
+	contents, err := ioutil.ReadFile(CellConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", CellConfigFile, err)
+	}
+	config, err := cellconfig.Unmarshal(contents)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling %s: %v", CellConfigFile, err)
+	}
 	return config, nil
 }
 
-func RunAddons() error {
+// ConfigureDownloads sets up proxy and TLS settings for downloads. Flags take
+// precedence over the cell config, where the CA bundle and the client
+// certificate and key can also be passed as PEM data.
+func ConfigureDownloads() {
 	config, err := readCellConfig()
 	if err != nil {
-		config = map[string]string{}
+		klog.Warningf("%v", err)
+		config = &cellconfig.Config{}
 	}
+	settings := []struct {
+		setting *string
+		flag    string
+		key     string
+	}{
+		{&util.DownloadProxy, *downloadProxy, "downloadProxy"},
+		{&util.DownloadCABundle, *downloadCABundle, "downloadCABundle"},
+		{&util.DownloadClientCert, *downloadClientCert, "downloadClientCert"},
+		{&util.DownloadClientKey, *downloadClientKey, "downloadClientKey"},
+	}
+	for _, s := range settings {
+		*s.setting = s.flag
+		if s.flag == "" && config.Settings[s.key] != "" {
+			klog.V(2).Infof("using %s from cell config", s.key)
+			*s.setting = config.Settings[s.key]
+		}
+	}
+}
+
+// addonCancels stops the background work of the addons that have been run,
+// before they are run again.
+var addonCancels = map[string]context.CancelFunc{}
+
+func runAddon(ctx context.Context, name string, addon addons.Plugin, config cellconfig.Section) error {
+	logging.SetField("addon", name)
+	defer logging.ClearField("addon")
+	if cancel, ok := addonCancels[name]; ok {
+		cancel()
+	}
+	ctx, addonCancels[name] = context.WithCancel(ctx)
+	klog.Infof("running addon %s", name)
+	start := time.Now()
+	phase := launcherStatus.Start("addon/" + name)
+	err := addon.Run(ctx, config)
+	launcherStatus.End(phase, err)
+	metrics.AddonRuns.WithLabelValues(name, metrics.Result(err)).Inc()
+	fields := logging.Fields{"duration": time.Since(start)}
+	if err != nil {
+		klog.Errorf("running %s: %v", name, err)
+		fields["error"] = err.Error()
+	} else {
+		klog.V(2).Infof("running %s: success", name)
+	}
+	logging.Infof(fields, "finished addon %s", name)
+	return err
+}
+
+func RunAddons(ctx context.Context, config *cellconfig.Config) error {
 	var errs error
 	klog.Infof("found %d addon(s)", len(addons.Registry))
 	for name, addon := range addons.Registry {
-		klog.Infof("running addon %s", name)
-		err := addon.Run(config)
+		err := runAddon(ctx, name, addon, config.Addons[name])
 		if err != nil {
 			errs = multierror.Append(errs, err)
-			klog.Errorf("running %s: %v", name, err)
-		} else {
-			klog.V(2).Infof("running %s: success", name)
 		}
 	}
 	return errs
 }
 
-func HandleSignal(sig chan os.Signal) {
+// ReloadAddons runs the addons again whose sections contain any of keys, as
+// returned by Config.Flatten.
+func ReloadAddons(ctx context.Context, config *cellconfig.Config, keys []string) error {
+	var errs error
+	for name, addon := range addons.Registry {
+		changed := false
+		for _, key := range keys {
+			if strings.HasPrefix(key, "addons."+name+".") {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			continue
+		}
+		err := runAddon(ctx, name, addon, config.Addons[name])
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+func warnIgnoredCellConfig(config *cellconfig.Config) {
+	if len(config.Ignored) > 0 {
+		klog.Warningf("ignoring cell config keys %s: only scalars, lists of itzo flag values, and the itzo and addons sections are supported, and each flag can only be set once",
+			strings.Join(config.Ignored, ", "))
+	}
+}
+
+// CellConfigChanged re-runs the addons whose sections changed, and restarts
+// itzo if its flags or environment changed.
+func CellConfigChanged(ctx context.Context, itzo *supervisor.Supervisor, config *cellconfig.Config, keys []string) {
+	warnIgnoredCellConfig(config)
+	err := ReloadAddons(ctx, config, keys)
+	if err != nil {
+		klog.Warningf("re-running addons: %v", err)
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, "itzo.") {
+			klog.Infof("itzo config changed, restarting itzo")
+			itzo.Reload()
+			return
+		}
+	}
+}
+
+func serveHTTP(name, addr string, handler http.Handler) {
+	klog.Infof("serving %s on %s", name, addr)
+	err := http.ListenAndServe(addr, handler)
+	klog.Errorf("serving %s on %s: %v", name, addr, err)
+}
+
+// ServeMetrics serves Prometheus metrics on addr until the launcher exits.
+func ServeMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	serveHTTP("metrics", addr, mux)
+}
+
+// runPhase runs one step of starting up, logging how long it took.
+func runPhase(name string, fn func() error) error {
+	logging.SetField("phase", name)
+	klog.Infof("starting %s", name)
+	start := time.Now()
+	phase := launcherStatus.Start(name)
+	err := fn()
+	launcherStatus.End(phase, err)
+	fields := logging.Fields{"duration": time.Since(start)}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	logging.Infof(fields, "finished %s", name)
+	return err
+}
+
+// HandleSignal stops addons running in the background, and forwards the
+// signal to itzo. If itzo has not been started yet, the launcher exits right
+// away. Another signal while itzo is shutting down kills it without waiting
+// for the rest of its grace period.
+func HandleSignal(sig chan os.Signal, cancel context.CancelFunc, itzo *supervisor.Supervisor) {
 	s := <-sig
-	klog.Fatalf("caught signal %v, exiting", s)
+	klog.Infof("caught signal %v, shutting down", s)
+	cancel()
+	if !itzo.Stop(s) {
+		klog.Infof("itzo is not running, exiting")
+		klog.Flush()
+		os.Exit(0)
+	}
+	for s := range sig {
+		klog.Warningf("caught signal %v again, killing itzo", s)
+		itzo.Kill()
+	}
 }
 
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	format, err := logging.ParseFormat(*logFormat)
+	if err != nil {
+		klog.Fatalf("%v", err)
+	}
+	err = logging.Setup(format)
+	if err != nil {
+		klog.Fatalf("setting up logging: %v", err)
+	}
+
 	if *version {
 		fmt.Printf("%s version %s built on %s\n", filepath.Base(os.Args[0]), BuildVersion, BuildTime)
 		os.Exit(0)
@@ -240,35 +1136,117 @@ func main() {
 
 	klog.Infof("starting up")
 
+	launcherStatus = status.New(*statusFile, BuildVersion)
+	if *metricsAddr != "" {
+		go ServeMetrics(*metricsAddr)
+	}
+
+	util.DownloadRetries = *downloadRetries
+	util.DownloadTimeout = *downloadTimeout
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	itzo := &supervisor.Supervisor{
+		GracePeriod: *itzoGracePeriod,
+	}
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	go HandleSignal(sig)
+	go HandleSignal(sig, cancel, itzo)
 
-	err := os.MkdirAll(*itzoLogDir, 0755)
+	checker := &health.Checker{
+		URL:              *itzoHealthURL,
+		Interval:         *itzoHealthInterval,
+		Timeout:          *itzoHealthTimeout,
+		StartTimeout:     *itzoHealthStartTimeout,
+		FailureThreshold: *itzoHealthFailureThreshold,
+		Unhealthy: func(err error) {
+			klog.Warningf("restarting unresponsive itzo")
+			itzo.Restart()
+		},
+	}
+	if *healthAddr != "" {
+		go serveHTTP("health checks", *healthAddr, checker.Handler())
+	}
+	if *itzoHealthURL != "" {
+		go checker.Run(ctx)
+	}
+
+	err = os.MkdirAll(*itzoLogDir, 0755)
 	if err != nil {
 		klog.Fatalf("ensuring %s exists: %v", *itzoLogDir, err)
 	}
 
-	err = ProcessInstanceParameters()
+	err = LoadConfig()
 	if err != nil {
-		klog.Warningf("failed to process instance parameters: %v, falling back to user-data", err)
-		err = ProcessUserData()
-		if err != nil {
-			klog.Fatalf("processing cloud-init user data: %v", err)
-		}
+		klog.Fatalf("%v", err)
 	}
 
-	err = RunAddons()
+	cellConfig, err := readCellConfig()
+	if err != nil {
+		klog.Warningf("%v", err)
+		cellConfig = &cellconfig.Config{}
+	}
+	warnIgnoredCellConfig(cellConfig)
+	err = runPhase("addons", func() error {
+		return RunAddons(ctx, cellConfig)
+	})
 	if err != nil {
 		klog.Warningf("running addons: %v", err)
 	}
 
-	itzoPath, err := EnsureItzo()
+	ConfigureDownloads()
+
+	var itzoPath string
+	err = runPhase("download-itzo", func() error {
+		var err error
+		itzoPath, err = EnsureItzo()
+		return err
+	})
 	if err != nil {
 		klog.Fatalf("downloading itzo: %v", err)
 	}
+	itzoVersion, err := util.ProgVersion(itzoPath, "--version")
+	if err != nil {
+		klog.Warningf("checking itzo version: %v", err)
+	} else {
+		logging.SetField("itzoVersion", itzoVersion)
+	}
+
+	if *cellConfigWatchInterval > 0 {
+		// The watcher compares flattened configs; latest is the config the
+		// last flattened one came from.
+		latest := cellConfig
+		watcher := &configwatch.Watcher{
+			Interval: *cellConfigWatchInterval,
+			Read: func() (map[string]string, error) {
+				config, err := readCellConfig()
+				if err != nil {
+					return nil, err
+				}
+				latest = config
+				return config.Flatten(), nil
+			},
+			Changed: func(old, new map[string]string, keys []string) {
+				CellConfigChanged(ctx, itzo, latest, keys)
+			},
+		}
+		go watcher.Run(ctx, cellConfig.Flatten())
+	}
+	if *configPollInterval > 0 {
+		go PollConfig(ctx, *configPollInterval)
+	}
 
-	err = RunItzo(itzoPath, *itzoLogDir)
+	logging.SetField("phase", "itzo")
+	phase := launcherStatus.Start("itzo")
+	err = RunItzo(itzo, checker, itzoPath, *itzoLogDir)
+	launcherStatus.End(phase, err)
+	if ctx.Err() != nil {
+		// Stopped via a signal, exit with the status itzo exited with.
+		code := supervisor.ExitCode(err)
+		klog.Infof("itzo exited with status %d, exiting", code)
+		klog.Flush()
+		os.Exit(code)
+	}
 	if err != nil {
 		klog.Fatalf("running %q: %v", itzoPath, err)
 	}
@@ -1,11 +1,13 @@
 package addons
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os/exec"
 	"strings"
 
+	"github.com/elotl/itzo-launcher/pkg/cellconfig"
 	"k8s.io/klog"
 )
 
@@ -46,13 +48,9 @@ func restartAWSCWAgent() error {
 	return nil
 }
 
-func (a *AWSCWAgentAddon) Run(config map[string]string) error {
-	vars := make(map[string]string)
-	for k, v := range config {
-		if strings.HasPrefix(k, "awsCWAgent") && len(k) > 10 {
-			vars[k[10:]] = v
-		}
-	}
+func (a *AWSCWAgentAddon) Run(ctx context.Context, config cellconfig.Section) error {
+	// Every key in the section is a variable in the agent config.
+	vars := config
 	if len(vars) == 0 {
 		klog.V(2).Infof("no AWS CW agent configuration found")
 		return nil
@@ -1,12 +1,13 @@
 package addons
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/elotl/itzo-launcher/pkg/cellconfig"
+	"github.com/elotl/itzo-launcher/pkg/imds"
 	"k8s.io/klog"
 )
 
@@ -24,13 +25,9 @@ func init() {
 }
 
 func autoDetectRegion() string {
-	sess, err := session.NewSession()
-	if err != nil {
-		klog.Warningf("creating session to autodetect AWS region: %v", err)
-		return ""
-	}
-	client := ec2metadata.New(sess)
-	region, err := client.Region()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	region, err := imds.Default().Region(ctx)
 	if err != nil {
 		klog.Warningf("trying to autodetect AWS region: %v", err)
 		return ""
@@ -56,17 +53,20 @@ func configureVariables(clusterName, region string) error {
 	return nil
 }
 
-func waitForIAMRole() {
+func waitForIAMRole(ctx context.Context) {
 	for {
-		time.Sleep(3 * time.Second)
+		select {
+		case <-ctx.Done():
+			klog.V(2).Infof("stopped waiting for IAM role for fluentd")
+			return
+		case <-time.After(3 * time.Second):
+		}
 		klog.V(2).Infof("checking if IAM role for fluentd is now available")
-		sess, err := session.NewSession()
-		if err != nil {
-			klog.Warningf("creating AWS session for checking credentials: %v", err)
+		content, err := imds.Default().GetMetadata(ctx, "iam/security-credentials/")
+		if err != nil && !imds.IsNotFound(err) {
+			klog.Warningf("checking IAM role for fluentd: %v", err)
 			continue
 		}
-		client := ec2metadata.New(sess)
-		content, err := client.GetMetadata("iam/security-credentials")
 		if err != nil || len(content) < 1 {
 			continue
 		}
@@ -76,16 +76,9 @@ func waitForIAMRole() {
 	}
 }
 
-func (f *FluentdAWSAddon) Run(config map[string]string) error {
-	clusterName := ""
-	region := ""
-	for k, v := range config {
-		if k == "fluentdAWSClusterName" {
-			clusterName = v
-		} else if k == "fluentdAWSRegion" {
-			region = v
-		}
-	}
+func (f *FluentdAWSAddon) Run(ctx context.Context, config cellconfig.Section) error {
+	clusterName := config["clusterName"]
+	region := config["region"]
 	if region == "" {
 		region = autoDetectRegion()
 	}
@@ -106,6 +99,6 @@ func (f *FluentdAWSAddon) Run(config map[string]string) error {
 	// AWS library the cloudwatch plugin uses only checks the role at startup.
 	// To ensure credentials are configured for the plugin, we'll need to
 	// restart fluentd after the role has been attached to the instance.
-	go waitForIAMRole()
+	go waitForIAMRole(ctx)
 	return nil
 }
@@ -1,11 +1,13 @@
 package addons
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 
+	"github.com/elotl/itzo-launcher/pkg/cellconfig"
 	"github.com/elotl/itzo-launcher/pkg/mount"
 	"k8s.io/klog"
 )
@@ -63,18 +65,15 @@ func (n *NFSAddon) createLinks(mountDir string) error {
 	return nil
 }
 
-func (n *NFSAddon) Run(config map[string]string) error {
-	endpoint := ""
+func (n *NFSAddon) Run(ctx context.Context, config cellconfig.Section) error {
+	endpoint := config["endpoint"]
 	mountDir := "/nfs"
+	if config["mountDir"] != "" {
+		mountDir = config["mountDir"]
+	}
 	mountOpts := "-o ro"
-	for k, v := range config {
-		if k == "imageCacheEndpoint" {
-			endpoint = v
-		} else if k == "imageCacheMountDir" {
-			mountDir = v
-		} else if k == "imageCacheMountOpts" {
-			mountOpts = v
-		}
+	if config["mountOpts"] != "" {
+		mountOpts = config["mountOpts"]
 	}
 	if endpoint == "" {
 		return nil
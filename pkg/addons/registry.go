@@ -1,7 +1,16 @@
 package addons
 
+import (
+	"context"
+
+	"github.com/elotl/itzo-launcher/pkg/cellconfig"
+)
+
 type Plugin interface {
-	Run(config map[string]string) error
+	// Run configures the addon from its section of the cell config, which is
+	// nil if there is none. Background work started by the addon has to stop
+	// when ctx is done.
+	Run(ctx context.Context, config cellconfig.Section) error
 }
 
 var Registry = map[string]Plugin{}
@@ -0,0 +1,297 @@
+package cellconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ItzoFlagPrefix is the prefix of flat keys holding itzo flags, e.g.
+// "itzoFlag-use-podman".
+const ItzoFlagPrefix = "itzoFlag"
+
+// DefaultItzoFlags are passed to itzo unless the cell config sets or unsets
+// them. Keys are flag names without leading dashes.
+var DefaultItzoFlags = map[string]string{"v": "5"}
+
+// Config is the cell config, from cell_config.yaml:
+//
+//	itzo:
+//	  flags:
+//	    use-podman: true
+//	    mount: [/a, /b]
+//	  env:
+//	    HTTPS_PROXY: http://proxy:3128
+//	addons:
+//	  nfs:
+//	    endpoint: 10.0.0.2:/images
+//	itzoLogMaxSize: 50
+//
+// Flat keys of older cell configs, like "itzoFlag-use-podman" or
+// "imageCacheEndpoint", are mapped into their sections. Where both are set,
+// the nested one wins. Other top level keys holding maps are ignored.
+type Config struct {
+	Itzo Itzo `yaml:"itzo"`
+	// Addons holds the config sections of addons, by addon name.
+	Addons map[string]Section `yaml:"addons"`
+	// Settings holds the other top level keys, e.g. itzoLogMaxSize or
+	// downloadProxy.
+	Settings map[string]string `yaml:"-"`
+	// Ignored lists the keys that could not be used.
+	Ignored []string `yaml:"-"`
+}
+
+// Itzo configures how itzo is run.
+type Itzo struct {
+	// Flags maps itzo flag names, without leading dashes, to their values.
+	Flags map[string]Values `yaml:"flags"`
+	// Env holds extra environment variables for itzo.
+	Env map[string]string `yaml:"env"`
+}
+
+// Section is the config of an addon.
+type Section map[string]string
+
+// Values is a YAML scalar or list. An empty scalar or null is an empty list.
+type Values []string
+
+func (v *Values) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal(&s)
+	if err == nil {
+		*v = Values{}
+		if s != "" {
+			*v = Values{s}
+		}
+		return nil
+	}
+	var list []string
+	if unmarshal(&list) != nil {
+		return err
+	}
+	*v = Values(list)
+	return nil
+}
+
+// flatAddonKeys maps flat keys of older cell configs to addon sections.
+var flatAddonKeys = map[string]struct{ addon, key string }{
+	"imageCacheEndpoint":    {"nfs", "endpoint"},
+	"imageCacheMountDir":    {"nfs", "mountDir"},
+	"imageCacheMountOpts":   {"nfs", "mountOpts"},
+	"fluentdAWSClusterName": {"fluentd-aws", "clusterName"},
+	"fluentdAWSRegion":      {"fluentd-aws", "region"},
+}
+
+// flatAddonPrefixes maps prefixes of flat keys to addon sections. The rest of
+// the key is the key in the section.
+var flatAddonPrefixes = map[string]string{
+	"awsCWAgent": "aws-cw-agent",
+}
+
+// flatValue is the value of a flat key. Values that are neither scalars nor
+// lists of scalars, e.g. maps, are not used.
+type flatValue struct {
+	values  Values
+	invalid bool
+}
+
+func (v *flatValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if unmarshal(&v.values) == nil {
+		return nil
+	}
+	var ignored interface{}
+	v.invalid = true
+	return unmarshal(&ignored)
+}
+
+// rawConfig is Config as it's stored, with the flat keys still in Flat.
+type rawConfig struct {
+	Itzo   Itzo                 `yaml:"itzo"`
+	Addons map[string]Section   `yaml:"addons"`
+	Flat   map[string]flatValue `yaml:",inline"`
+}
+
+func sortedKeys(m map[string]Values) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Unmarshal decodes a cell config, mapping flat keys into their sections.
+// Keys that can't be used, e.g. top level keys holding maps, or a flag given
+// more than once, are listed in Ignored.
+func Unmarshal(contents []byte) (*Config, error) {
+	raw := rawConfig{}
+	err := yaml.Unmarshal(contents, &raw)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{
+		Itzo: Itzo{
+			Flags: make(map[string]Values),
+			Env:   make(map[string]string),
+		},
+		Addons:   make(map[string]Section),
+		Settings: make(map[string]string),
+		Ignored:  make([]string, 0),
+	}
+	// Keys are processed in sorted order, so if a flag is given more than
+	// once, e.g. as "-x" and "--x", the same one always wins.
+	for _, name := range sortedKeys(raw.Itzo.Flags) {
+		if !config.Itzo.setFlag(name, raw.Itzo.Flags[name]) {
+			config.Ignored = append(config.Ignored, "itzo.flags."+name)
+		}
+	}
+	for k, v := range raw.Itzo.Env {
+		config.Itzo.Env[k] = v
+	}
+	for name, section := range raw.Addons {
+		config.Addons[name] = section
+	}
+	flat := make(map[string]Values, len(raw.Flat))
+	for key, value := range raw.Flat {
+		if value.invalid {
+			config.Ignored = append(config.Ignored, key)
+			continue
+		}
+		flat[key] = value.values
+	}
+	nestedFlags := make(map[string]bool, len(config.Itzo.Flags))
+	for name := range config.Itzo.Flags {
+		nestedFlags[name] = true
+	}
+	for _, key := range sortedKeys(flat) {
+		if !config.setFlat(key, flat[key], nestedFlags) {
+			config.Ignored = append(config.Ignored, key)
+		}
+	}
+	sort.Strings(config.Ignored)
+	return config, nil
+}
+
+// setFlag sets an itzo flag, unless it's already set. It returns whether the
+// flag was set.
+func (i *Itzo) setFlag(name string, values Values) bool {
+	name = strings.TrimLeft(name, "-")
+	if name == "" {
+		return false
+	}
+	if _, ok := i.Flags[name]; ok {
+		return false
+	}
+	i.Flags[name] = values
+	return true
+}
+
+// setFlat sets a flat key, unless its nested equivalent is set. Flags in
+// nestedFlags were set in the itzo section. It returns false if the key can't
+// be used.
+func (c *Config) setFlat(key string, values Values, nestedFlags map[string]bool) bool {
+	if strings.HasPrefix(key, ItzoFlagPrefix) {
+		name := strings.TrimPrefix(key, ItzoFlagPrefix)
+		if nestedFlags[strings.TrimLeft(name, "-")] {
+			return true
+		}
+		return c.Itzo.setFlag(name, values)
+	}
+	if len(values) > 1 {
+		// Only itzo flags can have a list of values.
+		return false
+	}
+	value := ""
+	if len(values) == 1 {
+		value = values[0]
+	}
+	addon, addonKey := "", ""
+	if mapping, ok := flatAddonKeys[key]; ok {
+		addon, addonKey = mapping.addon, mapping.key
+	}
+	for prefix, name := range flatAddonPrefixes {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			addon, addonKey = name, key[len(prefix):]
+		}
+	}
+	if addon == "" {
+		c.Settings[key] = value
+		return true
+	}
+	section := c.Addons[addon]
+	if section == nil {
+		section = make(Section)
+		c.Addons[addon] = section
+	}
+	if _, ok := section[addonKey]; !ok {
+		section[addonKey] = value
+	}
+	return true
+}
+
+// Args returns the command line arguments for itzo, merged with
+// DefaultItzoFlags. Flags are sorted by name, and passed as --flag=value. A
+// value of "true" is passed as a bare --flag switch, a list of values as a
+// repeated flag, and an empty value unsets the flag, including a default one.
+func (i *Itzo) Args() []string {
+	flags := make(map[string]Values, len(DefaultItzoFlags)+len(i.Flags))
+	for name, value := range DefaultItzoFlags {
+		flags[name] = Values{value}
+	}
+	for name, values := range i.Flags {
+		flags[name] = values
+	}
+	names := make([]string, 0, len(flags))
+	for name, values := range flags {
+		if len(values) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	args := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range flags[name] {
+			if value == "true" {
+				args = append(args, "--"+name)
+				continue
+			}
+			args = append(args, fmt.Sprintf("--%s=%s", name, value))
+		}
+	}
+	return args
+}
+
+// Environ returns the extra environment variables for itzo as sorted
+// KEY=value pairs.
+func (i *Itzo) Environ() []string {
+	env := make([]string, 0, len(i.Env))
+	for k, v := range i.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Flatten returns the config as a flat map, with keys like
+// "itzo.flags.use-podman", "addons.nfs.endpoint" or "itzoLogMaxSize", for
+// comparing configs.
+func (c *Config) Flatten() map[string]string {
+	flat := make(map[string]string)
+	for name, values := range c.Itzo.Flags {
+		flat["itzo.flags."+name] = fmt.Sprintf("%q", []string(values))
+	}
+	for k, v := range c.Itzo.Env {
+		flat["itzo.env."+k] = v
+	}
+	for name, section := range c.Addons {
+		for k, v := range section {
+			flat["addons."+name+"."+k] = v
+		}
+	}
+	for k, v := range c.Settings {
+		flat[k] = v
+	}
+	return flat
+}
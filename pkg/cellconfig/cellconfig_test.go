@@ -0,0 +1,193 @@
+package cellconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshal(t *testing.T) {
+	contents := `
+itzo:
+  flags:
+    --custom-port: 1234
+    mount: [/a, /b]
+  env:
+    HTTPS_PROXY: http://proxy:3128
+addons:
+  nfs:
+    endpoint: 10.0.0.2:/images
+itzoFlag-use-podman: true
+itzoFlag-custom-port: 4321
+itzoFlag--v:
+imageCacheEndpoint: 10.0.0.3:/images
+imageCacheMountDir: /mnt
+fluentdAWSClusterName: cluster
+awsCWAgentLogGroup: group
+itzoLogMaxSize: 50
+`
+	config, err := Unmarshal([]byte(contents))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Values{
+		"custom-port": {"1234"},
+		"mount":       {"/a", "/b"},
+		"use-podman":  {"true"},
+		"v":           nil,
+	}, config.Itzo.Flags)
+	assert.Equal(t, map[string]string{"HTTPS_PROXY": "http://proxy:3128"}, config.Itzo.Env)
+	assert.Equal(t, map[string]Section{
+		"nfs": {
+			"endpoint": "10.0.0.2:/images",
+			"mountDir": "/mnt",
+		},
+		"fluentd-aws":  {"clusterName": "cluster"},
+		"aws-cw-agent": {"LogGroup": "group"},
+	}, config.Addons)
+	assert.Equal(t, map[string]string{"itzoLogMaxSize": "50"}, config.Settings)
+}
+
+func TestUnmarshalEmpty(t *testing.T) {
+	config, err := Unmarshal([]byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--v=5"}, config.Itzo.Args())
+	assert.Empty(t, config.Itzo.Environ())
+	assert.Empty(t, config.Addons["nfs"]["endpoint"])
+	assert.Empty(t, config.Flatten())
+}
+
+func TestUnmarshalIgnored(t *testing.T) {
+	contents := `
+itzo:
+  flags:
+    --x: 3
+foo:
+  bar: baz
+downloadProxy: [a, b]
+itzoFlag-y: 1
+itzoFlag--y: 2
+itzoFlag--z: [1, 2]
+itzoFlag-x: 4
+addons:
+  nfs:
+    endpoint: 10.0.0.2:/images
+`
+	// Parse it a few times, since maps are iterated in random order.
+	for i := 0; i < 20; i++ {
+		config, err := Unmarshal([]byte(contents))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"downloadProxy", "foo", "itzoFlag-y"}, config.Ignored)
+		assert.Equal(t, []string{"--v=5", "--x=3", "--y=2", "--z=1", "--z=2"}, config.Itzo.Args())
+		assert.Equal(t, "10.0.0.2:/images", config.Addons["nfs"]["endpoint"])
+		assert.Empty(t, config.Settings)
+	}
+}
+
+func TestUnmarshalDuplicateFlags(t *testing.T) {
+	contents := `
+itzo:
+  flags:
+    -x: 1
+    --x: 2
+`
+	for i := 0; i < 20; i++ {
+		config, err := Unmarshal([]byte(contents))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"itzo.flags.-x"}, config.Ignored)
+		assert.Equal(t, Values{"2"}, config.Itzo.Flags["x"])
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	testCases := []string{
+		"itzo: [a, b]",
+		"addons:\n  nfs:\n    endpoint:\n      nested: map\n",
+	}
+	for _, contents := range testCases {
+		_, err := Unmarshal([]byte(contents))
+		assert.Error(t, err, contents)
+	}
+}
+
+func TestItzoArgs(t *testing.T) {
+	testCases := []struct {
+		name  string
+		flags map[string]Values
+		args  []string
+	}{
+		{
+			name:  "no flags",
+			flags: nil,
+			args:  []string{"--v=5"},
+		},
+		{
+			name: "flags",
+			flags: map[string]Values{
+				"use-podman":  {"true"},
+				"custom-port": {"1234"},
+				"debug":       {"false"},
+			},
+			// --v=5 is a default flag.
+			args: []string{"--custom-port=1234", "--debug=false", "--use-podman", "--v=5"},
+		},
+		{
+			name:  "override default",
+			flags: map[string]Values{"v": {"2"}},
+			args:  []string{"--v=2"},
+		},
+		{
+			name: "unset default",
+			flags: map[string]Values{
+				"v":          {},
+				"use-podman": {"true"},
+			},
+			args: []string{"--use-podman"},
+		},
+		{
+			name: "repeated flag",
+			flags: map[string]Values{
+				"mount":  {"/a", "/b:/c"},
+				"listen": {"[::1]"},
+			},
+			args: []string{"--listen=[::1]", "--mount=/a", "--mount=/b:/c", "--v=5"},
+		},
+		{
+			name:  "value with spaces",
+			flags: map[string]Values{"name": {"a b"}},
+			args:  []string{"--name=a b", "--v=5"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			itzo := Itzo{Flags: tc.flags}
+			assert.Equal(t, tc.args, itzo.Args())
+		})
+	}
+}
+
+func TestItzoEnviron(t *testing.T) {
+	itzo := Itzo{Env: map[string]string{"B": "2", "A": "1 2"}}
+	assert.Equal(t, []string{"A=1 2", "B=2"}, itzo.Environ())
+}
+
+func TestFlatten(t *testing.T) {
+	config, err := Unmarshal([]byte(`
+itzo:
+  flags:
+    mount: [/a, /b]
+  env:
+    A: "1"
+addons:
+  nfs:
+    endpoint: 10.0.0.2:/images
+itzoFlag-v: 2
+itzoLogMaxSize: 50
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"itzo.flags.mount":    `["/a" "/b"]`,
+		"itzo.flags.v":        `["2"]`,
+		"itzo.env.A":          "1",
+		"addons.nfs.endpoint": "10.0.0.2:/images",
+		"itzoLogMaxSize":      "50",
+	}, config.Flatten())
+}
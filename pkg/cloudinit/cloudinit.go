@@ -29,25 +29,28 @@ const (
 	datasourceTimeout     = 5 * time.Minute
 )
 
-func WriteFiles(paths ...string) error {
+// WriteFiles saves the files in paths from the write_files section of the
+// user data into dir, using their base names. It returns the type of the
+// datasource the user data came from.
+func WriteFiles(dir string, paths ...string) (string, error) {
 	dss := getDatasources()
 	if len(dss) == 0 {
-		return fmt.Errorf("no datasources configured")
+		return "", fmt.Errorf("no datasources configured")
 	}
 
 	ds := selectDatasource(dss)
 	if ds == nil {
-		return fmt.Errorf("no datasources available in time")
+		return "", fmt.Errorf("no datasources available in time")
 	}
 
 	klog.V(2).Infof("fetching user-data from datasource of type %q\n", ds.Type())
 	userdataBytes, err := ds.FetchUserdata()
 	if err != nil {
-		return fmt.Errorf("fetching user-data from datasource: %v", err)
+		return "", fmt.Errorf("fetching user-data from datasource: %v", err)
 	}
 	userdataBytes, err = decompressIfGzip(userdataBytes)
 	if err != nil {
-		return fmt.Errorf("decompressing user-data from datasource: %v. Continuing...\n", err)
+		return "", fmt.Errorf("decompressing user-data from datasource: %v. Continuing...\n", err)
 	}
 
 	if report, err := validate.Validate(userdataBytes); err == nil {
@@ -55,27 +58,26 @@ func WriteFiles(paths ...string) error {
 			klog.Infof("validating userdata: %v", e)
 		}
 	} else {
-		return fmt.Errorf("validating userdata: %v", err)
+		return "", fmt.Errorf("validating userdata: %v", err)
 	}
 
 	klog.V(2).Infof("fetching meta-data from datasource of type %q", ds.Type())
 
 	cc, err := config.NewCloudConfig(string(userdataBytes))
 	if err != nil {
-		return err
+		return "", err
 	}
 	if err := cc.Decode(); err != nil {
-		return err
+		return "", err
 	}
 
+	// ensure dir exists prior to write
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
 	for _, wf := range cc.WriteFiles {
 		for _, p := range paths {
-			// ensure dir exists for all paths prior to write
-			fileDir := filepath.Dir(p)
-			err := os.MkdirAll(fileDir, os.ModeDir)
-			if err != nil {
-				return err
-			}
 			if wf.Path == p {
 				permStr := wf.RawFilePermissions
 				perm, err := strconv.ParseInt(permStr, 0, 32)
@@ -83,16 +85,17 @@ func WriteFiles(paths ...string) error {
 					klog.Warningf("parsing permission %s: %v", permStr, err)
 					perm = 0644
 				}
-				err = ioutil.WriteFile(p, []byte(wf.Content), os.FileMode(perm))
+				dst := filepath.Join(dir, filepath.Base(p))
+				err = ioutil.WriteFile(dst, []byte(wf.Content), os.FileMode(perm))
 				if err != nil {
-					return err
+					return "", err
 				}
-				klog.Infof("saved %s", p)
+				klog.Infof("saved %s to %s", p, dst)
 			}
 		}
 	}
 
-	return nil
+	return ds.Type(), nil
 }
 
 // getDatasources creates a slice of possible Datasources for cloudinit based
@@ -0,0 +1,286 @@
+package configstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	filesDir     = "files"
+	// syncedFile lists the files SyncFiles and Restore put into a directory,
+	// so the ones that are dropped from the config can be removed later.
+	syncedFile = ".configstore-files"
+)
+
+// Snapshot describes the last config that was fetched successfully.
+type Snapshot struct {
+	// Fetched is when the config was fetched.
+	Fetched time.Time `json:"fetched"`
+	// Source is where it was fetched from, e.g. instance parameters or
+	// user-data.
+	Source string `json:"source"`
+	// Files maps the names of the config files to their SHA-256 digests.
+	Files map[string]string `json:"files"`
+}
+
+// Age returns the time since the config was fetched.
+func (s *Snapshot) Age() time.Duration {
+	return time.Since(s.Fetched)
+}
+
+// Store keeps the last known good config files in a persistent directory, so
+// they survive reboots.
+type Store struct {
+	dir string
+}
+
+func New(dir string) *Store {
+	return &Store{
+		dir: dir,
+	}
+}
+
+// Load returns the saved snapshot, or nil if there is none.
+func (s *Store) Load() (*Snapshot, error) {
+	path := filepath.Join(s.dir, snapshotFile)
+	contents, err := ioutil.ReadFile(path)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	snapshot := &Snapshot{}
+	err = json.Unmarshal(contents, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", path, err)
+	}
+	return snapshot, nil
+}
+
+// Save stores the config files in srcDir, fetched from source. It returns
+// whether they differ from the ones saved previously. Callers have to make
+// sure that only one Save runs at a time for the same directory.
+func (s *Store) Save(srcDir, source string) (bool, error) {
+	previous, err := s.Load()
+	if err != nil {
+		previous = nil
+	}
+	newDir := filepath.Join(s.dir, filesDir+".new")
+	err = os.RemoveAll(newDir)
+	if err != nil {
+		return false, fmt.Errorf("removing %s: %v", newDir, err)
+	}
+	files, err := CopyFiles(srcDir, newDir)
+	if err != nil {
+		return false, err
+	}
+	snapshot := &Snapshot{
+		Fetched: time.Now(),
+		Source:  source,
+		Files:   files,
+	}
+	// Swap the files first, then the snapshot. If we crash in between, the
+	// digests in the old snapshot won't match, and Restore will refuse to use
+	// the files.
+	dir := filepath.Join(s.dir, filesDir)
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return false, fmt.Errorf("removing %s: %v", dir, err)
+	}
+	err = os.Rename(newDir, dir)
+	if err != nil {
+		return false, fmt.Errorf("renaming %s to %s: %v", newDir, dir, err)
+	}
+	buf, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return false, fmt.Errorf("encoding snapshot: %v", err)
+	}
+	err = writeFileAtomic(filepath.Join(s.dir, snapshotFile), buf, 0600)
+	if err != nil {
+		return false, err
+	}
+	changed := previous == nil || !reflect.DeepEqual(previous.Files, files)
+	return changed, nil
+}
+
+// Restore copies the saved config files into dstDir, after checking that
+// they are intact. Like SyncFiles, it removes the files that are not part of
+// the saved config.
+func (s *Store) Restore(dstDir string, managed ...string) (*Snapshot, error) {
+	snapshot, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("no saved config in %s", s.dir)
+	}
+	dir := filepath.Join(s.dir, filesDir)
+	names := make([]string, 0, len(snapshot.Files))
+	for name, digest := range snapshot.Files {
+		actual, err := fileDigest(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if actual != digest {
+			return nil, fmt.Errorf("saved config file %s is corrupted", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := copyFile(filepath.Join(dir, name), filepath.Join(dstDir, name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = removeStale(dstDir, snapshot.Files, managed)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// SyncFiles copies the regular files in srcDir into dstDir like CopyFiles.
+// Files it put into dstDir before, and files named in managed, are removed if
+// they are not in srcDir anymore.
+func SyncFiles(srcDir, dstDir string, managed ...string) (map[string]string, error) {
+	files, err := CopyFiles(srcDir, dstDir)
+	if err != nil {
+		return nil, err
+	}
+	err = removeStale(dstDir, files, managed)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// removeStale removes the files in dir that are not in files, but were
+// synced there previously or are named in managed. Then it records files as
+// synced.
+func removeStale(dir string, files map[string]string, managed []string) error {
+	path := filepath.Join(dir, syncedFile)
+	stale := append([]string{}, managed...)
+	contents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading %s: %v", path, err)
+	} else if err == nil {
+		var synced []string
+		err = json.Unmarshal(contents, &synced)
+		if err != nil {
+			klog.Warningf("decoding %s: %v", path, err)
+		}
+		stale = append(stale, synced...)
+	}
+	for _, name := range stale {
+		if _, ok := files[name]; ok {
+			continue
+		}
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			// Only plain config files are ever removed.
+			continue
+		}
+		err := os.Remove(filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing %s: %v", filepath.Join(dir, name), err)
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf, err := json.Marshal(names)
+	if err != nil {
+		return fmt.Errorf("encoding synced files: %v", err)
+	}
+	return writeFileAtomic(path, buf, 0600)
+}
+
+// CopyFiles copies the regular files in srcDir into dstDir, replacing files
+// there atomically. It returns the names of the files copied with their
+// SHA-256 digests.
+func CopyFiles(srcDir, dstDir string) (map[string]string, error) {
+	infos, err := ioutil.ReadDir(srcDir)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %v", srcDir, err)
+	}
+	files := make(map[string]string)
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		src := filepath.Join(srcDir, info.Name())
+		err := copyFile(src, filepath.Join(dstDir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		digest, err := fileDigest(src)
+		if err != nil {
+			return nil, err
+		}
+		files[info.Name()] = digest
+	}
+	return files, nil
+}
+
+func fileDigest(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %v", path, err)
+	}
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func copyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("checking %s: %v", src, err)
+	}
+	contents, err := ioutil.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", src, err)
+	}
+	return writeFileAtomic(dst, contents, info.Mode().Perm())
+}
+
+func writeFileAtomic(path string, contents []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("creating %s: %v", dir, err)
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("creating temporary file in %s: %v", dir, err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %v", f.Name(), err)
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return fmt.Errorf("renaming %s to %s: %v", f.Name(), path, err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package configstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fetched := filepath.Join(dir, "fetched")
+	restored := filepath.Join(dir, "restored")
+	assert.NoError(t, os.MkdirAll(fetched, 0755))
+	writeFile := func(name, contents string) {
+		err := ioutil.WriteFile(filepath.Join(fetched, name), []byte(contents), 0600)
+		assert.NoError(t, err)
+	}
+	s := New(filepath.Join(dir, "state"))
+
+	snapshot, err := s.Load()
+	assert.NoError(t, err)
+	assert.Nil(t, snapshot)
+	_, err = s.Restore(restored)
+	assert.Error(t, err)
+
+	writeFile("itzo_version", "1.2.3")
+	writeFile("cell_config.yaml", "itzoFlag--v: \"3\"\n")
+	changed, err := s.Save(fetched, "user-data")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = s.Save(fetched, "user-data")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	snapshot, err = s.Restore(restored)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-data", snapshot.Source)
+	assert.Len(t, snapshot.Files, 2)
+	contents, err := ioutil.ReadFile(filepath.Join(restored, "itzo_version"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3", string(contents))
+
+	writeFile("itzo_version", "1.2.4")
+	changed, err = s.Save(fetched, "instance-parameters")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// Corrupted files are not restored.
+	err = ioutil.WriteFile(
+		filepath.Join(dir, "state", filesDir, "itzo_version"), []byte("x"), 0600)
+	assert.NoError(t, err)
+	_, err = s.Restore(restored)
+	assert.Error(t, err)
+}
+
+func TestSyncFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fetched := filepath.Join(dir, "fetched")
+	dst := filepath.Join(dir, "dst")
+	assert.NoError(t, os.MkdirAll(fetched, 0755))
+	assert.NoError(t, os.MkdirAll(dst, 0755))
+	writeFile := func(dir, name, contents string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600)
+		assert.NoError(t, err)
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dst, name))
+		return err == nil
+	}
+	s := New(filepath.Join(dir, "state"))
+
+	// Left behind by an older launcher, before anything was synced.
+	writeFile(dst, "itzo_url", "http://example.com")
+	// Not a config file.
+	writeFile(dst, "itzo.log", "")
+	writeFile(fetched, "itzo_version", "1.2.3")
+	writeFile(fetched, "itzo_sha256", "abc")
+	files, err := SyncFiles(fetched, dst, "itzo_url", "itzo_version", "itzo_sha256")
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.False(t, exists("itzo_url"))
+	assert.True(t, exists("itzo_version"))
+	assert.True(t, exists("itzo_sha256"))
+	assert.True(t, exists("itzo.log"))
+	_, err = s.Save(fetched, "user-data")
+	assert.NoError(t, err)
+
+	// A file dropped from the config is removed, even if it's not managed.
+	assert.NoError(t, os.Remove(filepath.Join(fetched, "itzo_sha256")))
+	writeFile(fetched, "itzo_version", "1.2.4")
+	_, err = SyncFiles(fetched, dst)
+	assert.NoError(t, err)
+	assert.False(t, exists("itzo_sha256"))
+	assert.True(t, exists("itzo_version"))
+	assert.True(t, exists("itzo.log"))
+
+	// Restoring removes the files that are not in the saved config, and puts
+	// back the ones that are.
+	writeFile(fetched, "cell_config.yaml", "")
+	_, err = SyncFiles(fetched, dst)
+	assert.NoError(t, err)
+	assert.True(t, exists("cell_config.yaml"))
+	_, err = s.Restore(dst)
+	assert.NoError(t, err)
+	assert.False(t, exists("cell_config.yaml"))
+	assert.True(t, exists("itzo_sha256"))
+	assert.True(t, exists("itzo.log"))
+	contents, err := ioutil.ReadFile(filepath.Join(dst, "itzo_version"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3", string(contents))
+}
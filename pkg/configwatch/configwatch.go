@@ -0,0 +1,64 @@
+package configwatch
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Watcher polls a config map, e.g. the cell config, and calls Changed with
+// the keys that were added, removed or modified whenever it changes.
+type Watcher struct {
+	Interval time.Duration
+	// Read returns the current config.
+	Read func() (map[string]string, error)
+	// Changed is called with the previous and the new config, and the sorted
+	// list of keys that differ between them.
+	Changed func(old, new map[string]string, keys []string)
+}
+
+// ChangedKeys returns the sorted list of keys that differ between old and
+// new.
+func ChangedKeys(old, new map[string]string) []string {
+	keys := make([]string, 0)
+	for k, v := range old {
+		if nv, ok := new[k]; !ok || nv != v {
+			keys = append(keys, k)
+		}
+	}
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Run polls the config every Interval, starting from config, until ctx is
+// done. If the config can't be read, the previous one is kept.
+func (w *Watcher) Run(ctx context.Context, config map[string]string) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current, err := w.Read()
+		if err != nil {
+			klog.V(2).Infof("checking config for changes: %v", err)
+			continue
+		}
+		keys := ChangedKeys(config, current)
+		if len(keys) == 0 {
+			continue
+		}
+		klog.Infof("config changed: %v", keys)
+		w.Changed(config, current, keys)
+		config = current
+	}
+}
@@ -0,0 +1,91 @@
+package configwatch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangedKeys(t *testing.T) {
+	testCases := []struct {
+		old  map[string]string
+		new  map[string]string
+		keys []string
+	}{
+		{
+			old:  nil,
+			new:  nil,
+			keys: []string{},
+		},
+		{
+			old:  map[string]string{"a": "1", "b": "2"},
+			new:  map[string]string{"a": "1", "b": "2"},
+			keys: []string{},
+		},
+		{
+			old:  map[string]string{"a": "1"},
+			new:  map[string]string{"a": "1", "c": "3"},
+			keys: []string{"c"},
+		},
+		{
+			old:  map[string]string{"a": "1", "b": "2"},
+			new:  map[string]string{"b": "3"},
+			keys: []string{"a", "b"},
+		},
+		{
+			old:  map[string]string{"a": ""},
+			new:  map[string]string{},
+			keys: []string{"a"},
+		},
+	}
+	for i, tc := range testCases {
+		msg := fmt.Sprintf("test case #%d %+v", i, tc)
+		assert.Equal(t, tc.keys, ChangedKeys(tc.old, tc.new), msg)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	var mu sync.Mutex
+	reads := 0
+	configs := []map[string]string{
+		{"a": "1"},
+		nil,
+		{"a": "2", "b": "1"},
+		{"a": "2", "b": "1"},
+	}
+	changes := make(chan []string, len(configs))
+	w := &Watcher{
+		Interval: time.Millisecond,
+		Read: func() (map[string]string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if reads >= len(configs) {
+				return configs[len(configs)-1], nil
+			}
+			config := configs[reads]
+			reads++
+			if config == nil {
+				return nil, fmt.Errorf("not found")
+			}
+			return config, nil
+		},
+		Changed: func(old, new map[string]string, keys []string) {
+			changes <- keys
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx, map[string]string{"a": "1"})
+	select {
+	case keys := <-changes:
+		assert.Equal(t, []string{"a", "b"}, keys)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+	cancel()
+	assert.Len(t, changes, 0)
+}
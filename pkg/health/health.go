@@ -0,0 +1,196 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+var errNotRunning = errors.New("itzo is not running")
+
+// Checker periodically probes the health endpoint of itzo while it's
+// running. Itzo is ready once a probe succeeded; if it stops answering for
+// FailureThreshold probes in a row, Unhealthy is called, e.g. to restart it.
+type Checker struct {
+	// URL of the itzo health endpoint. Any response other than a 5xx status
+	// counts as healthy; for HTTPS, certificates are not verified, since this
+	// is only a local probe. If it's empty, itzo is ready once it's running.
+	URL      string
+	Interval time.Duration
+	Timeout  time.Duration
+	// StartTimeout is how long itzo has after starting to answer, before
+	// failed probes are counted.
+	StartTimeout time.Duration
+	// FailureThreshold is the number of consecutive failed probes after which
+	// Unhealthy is called; 0 means never.
+	FailureThreshold int
+	Unhealthy        func(err error)
+
+	mu       sync.Mutex
+	running  bool
+	started  time.Time
+	ready    bool
+	failures int
+	lastErr  error
+	client   *http.Client
+}
+
+// Started is called when itzo has been (re)started.
+func (c *Checker) Started() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = true
+	c.started = time.Now()
+	c.ready = false
+	c.failures = 0
+	c.lastErr = nil
+}
+
+// Stopped is called when itzo has exited.
+func (c *Checker) Stopped() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = false
+	c.ready = false
+	c.lastErr = errNotRunning
+}
+
+// Ready returns nil if itzo is running and answers probes.
+func (c *Checker) Ready() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running {
+		return errNotRunning
+	}
+	if c.URL == "" {
+		// Probing is disabled.
+		return nil
+	}
+	if !c.ready {
+		if c.lastErr != nil {
+			return fmt.Errorf("itzo is not ready: %v", c.lastErr)
+		}
+		return errors.New("itzo is not ready yet")
+	}
+	return nil
+}
+
+func (c *Checker) httpClient() *http.Client {
+	if c.client == nil {
+		c.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy: nil,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		}
+	}
+	return c.client
+}
+
+func (c *Checker) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return fmt.Errorf("creating request for %s: %v", c.URL, err)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s returned %s", c.URL, resp.Status)
+	}
+	return nil
+}
+
+// check probes itzo once, and records the result.
+func (c *Checker) check(ctx context.Context) {
+	c.mu.Lock()
+	running := c.running
+	c.mu.Unlock()
+	if !running {
+		return
+	}
+	err := c.probe(ctx)
+	c.mu.Lock()
+	if !c.running {
+		// Exited while probing.
+		c.mu.Unlock()
+		return
+	}
+	c.lastErr = err
+	if err == nil {
+		if !c.ready {
+			klog.Infof("itzo is ready")
+		}
+		c.ready = true
+		c.failures = 0
+		c.mu.Unlock()
+		return
+	}
+	klog.V(2).Infof("probing itzo: %v", err)
+	if !c.ready && time.Since(c.started) < c.StartTimeout {
+		c.mu.Unlock()
+		return
+	}
+	c.ready = false
+	c.failures++
+	unhealthy := c.FailureThreshold > 0 && c.failures >= c.FailureThreshold
+	if unhealthy {
+		c.failures = 0
+		// Don't count failures again until the restarted itzo had time to
+		// come up.
+		c.started = time.Now()
+	}
+	c.mu.Unlock()
+	if unhealthy && c.Unhealthy != nil {
+		klog.Warningf("itzo failed %d health checks in a row: %v",
+			c.FailureThreshold, err)
+		c.Unhealthy(err)
+	}
+}
+
+// Run probes itzo every Interval until ctx is done.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		c.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Handler serves /healthz, which reports whether the launcher is up, and
+// /readyz, which reports whether itzo is ready.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		err := c.Ready()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
@@ -0,0 +1,87 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChecker(status *int32) (*Checker, *httptest.Server, *int32) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(int(atomic.LoadInt32(status)))
+		}))
+	unhealthy := int32(0)
+	c := &Checker{
+		URL:              server.URL,
+		Interval:         time.Millisecond,
+		Timeout:          time.Second,
+		FailureThreshold: 2,
+		Unhealthy: func(err error) {
+			atomic.AddInt32(&unhealthy, 1)
+		},
+	}
+	return c, server, &unhealthy
+}
+
+func readyz(c *Checker) int {
+	w := httptest.NewRecorder()
+	c.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	return w.Code
+}
+
+func TestCheckerReady(t *testing.T) {
+	status := int32(http.StatusOK)
+	c, server, unhealthy := newTestChecker(&status)
+	defer server.Close()
+	ctx := context.Background()
+
+	assert.Error(t, c.Ready())
+	assert.Equal(t, http.StatusServiceUnavailable, readyz(c))
+	c.check(ctx)
+	assert.Error(t, c.Ready())
+
+	c.Started()
+	assert.Error(t, c.Ready())
+	c.check(ctx)
+	assert.NoError(t, c.Ready())
+	assert.Equal(t, http.StatusOK, readyz(c))
+
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	c.check(ctx)
+	assert.Error(t, c.Ready())
+	assert.Equal(t, int32(0), atomic.LoadInt32(unhealthy))
+	c.check(ctx)
+	assert.Equal(t, int32(1), atomic.LoadInt32(unhealthy))
+
+	c.Stopped()
+	assert.Error(t, c.Ready())
+}
+
+func TestCheckerStartTimeout(t *testing.T) {
+	status := int32(http.StatusServiceUnavailable)
+	c, server, unhealthy := newTestChecker(&status)
+	defer server.Close()
+	c.StartTimeout = time.Hour
+	c.Started()
+	for i := 0; i < 5; i++ {
+		c.check(context.Background())
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(unhealthy))
+	c.StartTimeout = 0
+	c.check(context.Background())
+	c.check(context.Background())
+	assert.Equal(t, int32(1), atomic.LoadInt32(unhealthy))
+}
+
+func TestHealthz(t *testing.T) {
+	c := &Checker{}
+	w := httptest.NewRecorder()
+	c.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
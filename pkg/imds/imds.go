@@ -0,0 +1,266 @@
+package imds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	DefaultEndpoint = "http://169.254.169.254"
+	DefaultTokenTTL = 6 * time.Hour
+	DefaultTimeout  = 2 * time.Second
+
+	tokenPath         = "/latest/api/token"
+	metadataPath      = "/latest/meta-data/"
+	identityPath      = "/latest/dynamic/instance-identity/document"
+	tokenHeader       = "X-aws-ec2-metadata-token"
+	tokenTTLHeader    = "X-aws-ec2-metadata-token-ttl-seconds"
+	tokenRefreshSlack = 1 * time.Minute
+)
+
+// Error is an error response from the metadata service.
+type Error struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *Error) Error() string {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return fmt.Sprintf("getting %s from EC2 metadata service: unauthorized; IMDSv1 is disabled and no IMDSv2 token could be used", e.Path)
+	case http.StatusForbidden:
+		return fmt.Sprintf("getting %s from EC2 metadata service: forbidden; is the metadata service disabled on the instance?", e.Path)
+	case http.StatusNotFound:
+		return fmt.Sprintf("getting %s from EC2 metadata service: not found", e.Path)
+	}
+	return fmt.Sprintf("getting %s from EC2 metadata service: status %d", e.Path, e.StatusCode)
+}
+
+// IsNotFound checks whether err is a not found response.
+func IsNotFound(err error) bool {
+	var imdsErr *Error
+	return errors.As(err, &imdsErr) && imdsErr.StatusCode == http.StatusNotFound
+}
+
+// Client talks to the EC2 instance metadata service. It uses IMDSv2 session
+// tokens, refreshing them before they expire, and only falls back to IMDSv1
+// if the metadata service does not hand out tokens. Values that don't change
+// during the lifetime of an instance, like its region and instance ID, are
+// cached. It is safe for concurrent use.
+type Client struct {
+	Endpoint string
+	TokenTTL time.Duration
+	Timeout  time.Duration
+
+	mu          sync.Mutex
+	client      *http.Client
+	token       string
+	tokenExpiry time.Time
+	// useV1 is set if the metadata service does not support tokens.
+	useV1    bool
+	identity *IdentityDocument
+}
+
+// IdentityDocument is the instance identity document of an instance.
+type IdentityDocument struct {
+	InstanceID       string `json:"instanceId"`
+	Region           string `json:"region"`
+	AvailabilityZone string `json:"availabilityZone"`
+	AccountID        string `json:"accountId"`
+	InstanceType     string `json:"instanceType"`
+	ImageID          string `json:"imageId"`
+}
+
+var (
+	defaultClient     *Client
+	defaultClientOnce sync.Once
+)
+
+// Default returns the client shared by everything in the launcher talking
+// to the metadata service.
+func Default() *Client {
+	defaultClientOnce.Do(func() {
+		defaultClient = New(DefaultEndpoint)
+	})
+	return defaultClient
+}
+
+func New(endpoint string) *Client {
+	return &Client{
+		Endpoint: endpoint,
+		TokenTTL: DefaultTokenTTL,
+		Timeout:  DefaultTimeout,
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.client == nil {
+		// The metadata service is link-local, never use a proxy.
+		c.client = &http.Client{
+			Transport: &http.Transport{Proxy: nil},
+		}
+	}
+	return c.client
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// getToken returns a valid session token, fetching a new one if needed. An
+// empty token is returned if the metadata service only supports IMDSv1. The
+// caller must hold c.mu.
+func (c *Client) getToken(ctx context.Context) (string, error) {
+	if c.useV1 {
+		return "", nil
+	}
+	if c.token != "" && time.Now().Add(tokenRefreshSlack).Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPut, c.Endpoint+tokenPath, nil)
+	if err != nil {
+		return "", err
+	}
+	ttl := int(c.TokenTTL / time.Second)
+	req.Header.Set(tokenTTLHeader, strconv.Itoa(ttl))
+	start := time.Now()
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		if isTimeout(err) {
+			// The response to the token request is dropped if it would need
+			// more hops than the hop limit allows, e.g. from a container.
+			return "", fmt.Errorf("getting IMDSv2 token: %v; check that the metadata service is enabled, and that its hop limit is high enough for reaching it from here", err)
+		}
+		return "", fmt.Errorf("getting IMDSv2 token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading IMDSv2 token: %v", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		klog.V(2).Infof("EC2 metadata service does not support IMDSv2 tokens, falling back to IMDSv1")
+		c.useV1 = true
+		return "", nil
+	case http.StatusForbidden:
+		return "", fmt.Errorf("getting IMDSv2 token: forbidden; is the metadata service disabled on the instance?")
+	default:
+		return "", fmt.Errorf("getting IMDSv2 token: status %d", resp.StatusCode)
+	}
+	c.token = strings.TrimSpace(string(body))
+	c.tokenExpiry = start.Add(c.TokenTTL)
+	klog.V(4).Infof("got IMDSv2 token valid for %v", c.TokenTTL)
+	return c.token, nil
+}
+
+func (c *Client) get(ctx context.Context, path string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		token, err := c.getToken(ctx)
+		if err != nil {
+			return "", err
+		}
+		value, err := c.doGet(ctx, path, token)
+		var imdsErr *Error
+		if attempt == 0 && token != "" && errors.As(err, &imdsErr) &&
+			imdsErr.StatusCode == http.StatusUnauthorized {
+			// The token expired or was revoked, get a new one.
+			klog.V(2).Infof("IMDSv2 token was rejected, refreshing it")
+			c.token = ""
+			continue
+		}
+		return value, err
+	}
+}
+
+func (c *Client) doGet(ctx context.Context, path, token string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, c.Endpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Set(tokenHeader, token)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("getting %s from EC2 metadata service: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading %s from EC2 metadata service: %v", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &Error{Path: path, StatusCode: resp.StatusCode}
+	}
+	return string(body), nil
+}
+
+// GetMetadata returns the metadata value at path, e.g. "instance-id".
+func (c *Client) GetMetadata(ctx context.Context, path string) (string, error) {
+	return c.get(ctx, metadataPath+strings.TrimPrefix(path, "/"))
+}
+
+// IdentityDocument returns the instance identity document. It's only fetched
+// once.
+func (c *Client) IdentityDocument(ctx context.Context) (*IdentityDocument, error) {
+	c.mu.Lock()
+	identity := c.identity
+	c.mu.Unlock()
+	if identity != nil {
+		return identity, nil
+	}
+	doc, err := c.get(ctx, identityPath)
+	if err != nil {
+		return nil, err
+	}
+	identity = &IdentityDocument{}
+	err = json.Unmarshal([]byte(doc), identity)
+	if err != nil {
+		return nil, fmt.Errorf("decoding instance identity document: %v", err)
+	}
+	c.mu.Lock()
+	c.identity = identity
+	c.mu.Unlock()
+	return identity, nil
+}
+
+// Region returns the region of the instance.
+func (c *Client) Region(ctx context.Context) (string, error) {
+	identity, err := c.IdentityDocument(ctx)
+	if err != nil {
+		return "", err
+	}
+	return identity.Region, nil
+}
+
+// InstanceID returns the ID of the instance.
+func (c *Client) InstanceID(ctx context.Context) (string, error) {
+	identity, err := c.IdentityDocument(ctx)
+	if err != nil {
+		return "", err
+	}
+	return identity.InstanceID, nil
+}
@@ -0,0 +1,112 @@
+package imds
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeIMDS struct {
+	tokens      int32
+	identities  int32
+	v1          bool
+	tokenStatus int
+	token       string
+}
+
+func (f *fakeIMDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == tokenPath {
+		if r.Method != http.MethodPut || r.Header.Get(tokenTTLHeader) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if f.tokenStatus != 0 {
+			w.WriteHeader(f.tokenStatus)
+			return
+		}
+		n := atomic.AddInt32(&f.tokens, 1)
+		f.token = "token-" + string('0'+n)
+		w.Write([]byte(f.token))
+		return
+	}
+	if !f.v1 && (f.token == "" || r.Header.Get(tokenHeader) != f.token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case identityPath:
+		atomic.AddInt32(&f.identities, 1)
+		w.Write([]byte(`{"instanceId": "i-1234", "region": "us-east-1"}`))
+	case metadataPath + "iam/security-credentials/":
+		w.Write([]byte("my-role"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestClient(t *testing.T) {
+	fake := &fakeIMDS{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := New(server.URL)
+	ctx := context.Background()
+
+	region, err := c.Region(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", region)
+	instanceID, err := c.InstanceID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "i-1234", instanceID)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fake.identities))
+
+	role, err := c.GetMetadata(ctx, "iam/security-credentials/")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-role", role)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fake.tokens))
+
+	// Expiring tokens are refreshed.
+	c.tokenExpiry = time.Now()
+	_, err = c.GetMetadata(ctx, "iam/security-credentials/")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fake.tokens))
+
+	// Rejected tokens are refreshed.
+	fake.token = "revoked"
+	_, err = c.GetMetadata(ctx, "iam/security-credentials/")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fake.tokens))
+
+	_, err = c.GetMetadata(ctx, "placement/nonexistent")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestClientV1Fallback(t *testing.T) {
+	fake := &fakeIMDS{
+		v1:          true,
+		tokenStatus: http.StatusNotFound,
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := New(server.URL)
+	instanceID, err := c.InstanceID(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "i-1234", instanceID)
+}
+
+func TestClientTokenTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}))
+	defer server.Close()
+	c := New(server.URL)
+	c.Timeout = 10 * time.Millisecond
+	_, err := c.InstanceID(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "hop limit")
+}
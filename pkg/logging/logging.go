@@ -0,0 +1,177 @@
+package logging
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case FormatText, FormatJSON:
+		return Format(format), nil
+	}
+	return "", fmt.Errorf("invalid log format %q, use text or json", format)
+}
+
+// Fields are extra key/value pairs attached to log lines in JSON mode. In
+// text mode they are appended to the message as key=value.
+type Fields map[string]interface{}
+
+var (
+	mu     sync.Mutex
+	format = FormatText
+	out    io.Writer
+	fields = Fields{}
+)
+
+// Setup configures the output format of klog. In JSON mode every log line is
+// written to stderr as a JSON object, with the fields set via SetField.
+func Setup(f Format) error {
+	mu.Lock()
+	defer mu.Unlock()
+	format = f
+	if f != FormatJSON {
+		return nil
+	}
+	out = os.Stderr
+	// Make klog write only to the writer below. Severities above INFO are
+	// also written to the INFO writer, so the rest are discarded.
+	settings := map[string]string{
+		"logtostderr":     "false",
+		"alsologtostderr": "false",
+		"stderrthreshold": "4",
+	}
+	for name, value := range settings {
+		err := flag.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("setting klog flag %s: %v", name, err)
+		}
+	}
+	klog.SetOutputBySeverity("INFO", &klogWriter{})
+	for _, s := range []string{"WARNING", "ERROR", "FATAL"} {
+		klog.SetOutputBySeverity(s, ioutil.Discard)
+	}
+	return nil
+}
+
+// SetField adds a field to all subsequent log lines.
+func SetField(key string, value interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	fields[key] = value
+}
+
+// ClearField removes a field set via SetField.
+func ClearField(key string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(fields, key)
+}
+
+// Infof logs a message with extra fields.
+func Infof(extra Fields, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !isJSON() {
+		keys := make([]string, 0, len(extra))
+		for k := range extra {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			msg += fmt.Sprintf(" %s=%v", k, extra[k])
+		}
+		klog.InfoDepth(1, msg)
+		return
+	}
+	klog.Flush()
+	writeRecord("info", "", msg, extra)
+}
+
+func isJSON() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return format == FormatJSON
+}
+
+func writeRecord(level, source, msg string, extra Fields) {
+	mu.Lock()
+	defer mu.Unlock()
+	record := make(map[string]interface{}, len(fields)+len(extra)+4)
+	for k, v := range fields {
+		record[k] = v
+	}
+	for k, v := range extra {
+		if d, ok := v.(time.Duration); ok {
+			v = d.Seconds()
+		}
+		record[k] = v
+	}
+	record["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	record["level"] = level
+	if source != "" {
+		record["source"] = source
+	}
+	record["msg"] = msg
+	buf, err := json.Marshal(record)
+	if err != nil {
+		buf, _ = json.Marshal(map[string]string{
+			"level": "error",
+			"msg":   fmt.Sprintf("encoding log record %q: %v", msg, err),
+		})
+	}
+	out.Write(append(buf, '\n'))
+}
+
+var levels = map[byte]string{
+	'I': "info",
+	'W': "warning",
+	'E': "error",
+	'F': "fatal",
+}
+
+// klogWriter turns lines formatted by klog, e.g.
+// "I0701 12:00:00.000000   1234 file.go:12] message", into JSON records.
+type klogWriter struct{}
+
+func (w *klogWriter) Write(p []byte) (int, error) {
+	level, source, msg := parseKlogLine(string(p))
+	writeRecord(level, source, msg, nil)
+	return len(p), nil
+}
+
+func parseKlogLine(line string) (level, source, msg string) {
+	line = strings.TrimSuffix(line, "\n")
+	if len(line) == 0 {
+		return "info", "", line
+	}
+	level, ok := levels[line[0]]
+	if !ok {
+		return "info", "", line
+	}
+	i := strings.Index(line, "] ")
+	if i < 0 {
+		return level, "", line
+	}
+	header := strings.Fields(line[:i])
+	if len(header) > 0 {
+		source = header[len(header)-1]
+	}
+	return level, source, line[i+2:]
+}
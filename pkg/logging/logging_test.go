@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKlogLine(t *testing.T) {
+	testCases := []struct {
+		line   string
+		level  string
+		source string
+		msg    string
+	}{
+		{
+			line:   "I0701 12:00:00.000000   1234 main.go:12] starting up\n",
+			level:  "info",
+			source: "main.go:12",
+			msg:    "starting up",
+		},
+		{
+			line:   "E0701 12:00:00.000000    1 addons.go:7] running nfs: failed\n",
+			level:  "error",
+			source: "addons.go:7",
+			msg:    "running nfs: failed",
+		},
+		{
+			line:  "goroutine 1 [running]:\n",
+			level: "info",
+			msg:   "goroutine 1 [running]:",
+		},
+		{
+			line:  "",
+			level: "info",
+		},
+	}
+	for _, tc := range testCases {
+		level, source, msg := parseKlogLine(tc.line)
+		assert.Equal(t, tc.level, level, tc.line)
+		assert.Equal(t, tc.source, source, tc.line)
+		assert.Equal(t, tc.msg, msg, tc.line)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("json")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJSON, f)
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestStreamWriter(t *testing.T) {
+	var out bytes.Buffer
+	s := NewStreamWriter(&out, "stderr")
+	s.now = func() time.Time {
+		return time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	}
+	_, err := s.Write([]byte("first line\nsec"))
+	assert.NoError(t, err)
+	_, err = s.Write([]byte("ond \"line\"\nthird"))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Flush())
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, []string{
+		`{"time":"2020-07-01T12:00:00Z","stream":"stderr","msg":"first line"}`,
+		`{"time":"2020-07-01T12:00:00Z","stream":"stderr","msg":"second \"line\""}`,
+		`{"time":"2020-07-01T12:00:00Z","stream":"stderr","msg":"third"}`,
+	}, lines)
+}
+
+func TestStreamWriterLongLine(t *testing.T) {
+	defer func(n int) { MaxLineLength = n }(MaxLineLength)
+	MaxLineLength = 4
+	var out bytes.Buffer
+	s := NewStreamWriter(&out, "stdout")
+	_, err := s.Write([]byte("abcdefghij\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Flush())
+	assert.Equal(t, 3, strings.Count(out.String(), "\n"))
+	assert.Contains(t, out.String(), `"msg":"ij"`)
+}
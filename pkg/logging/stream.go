@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// MaxLineLength is the length output lines are split at if they don't end
+// in a newline.
+var MaxLineLength = 64 * 1024
+
+// StreamWriter wraps each line written to it in a JSON record with a
+// timestamp and the name of the stream, e.g. stdout or stderr, and writes it
+// to the underlying writer. It is used for capturing the output of itzo.
+type StreamWriter struct {
+	w      io.Writer
+	stream string
+	mu     sync.Mutex
+	buf    []byte
+	now    func() time.Time
+}
+
+func NewStreamWriter(w io.Writer, stream string) *StreamWriter {
+	return &StreamWriter{
+		w:      w,
+		stream: stream,
+		now:    time.Now,
+	}
+}
+
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 && len(s.buf) < MaxLineLength {
+			break
+		}
+		end, next := i, i+1
+		if i < 0 || i > MaxLineLength {
+			end, next = MaxLineLength, MaxLineLength
+		}
+		err := s.writeLine(s.buf[:end])
+		s.buf = s.buf[next:]
+		if err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+func (s *StreamWriter) writeLine(line []byte) error {
+	buf, err := json.Marshal(struct {
+		Time   string `json:"time"`
+		Stream string `json:"stream"`
+		Msg    string `json:"msg"`
+	}{
+		Time:   s.now().UTC().Format(time.RFC3339Nano),
+		Stream: s.stream,
+		Msg:    string(bytes.TrimSuffix(line, []byte("\r"))),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(buf, '\n'))
+	return err
+}
+
+// Flush writes out the last line, even if it's not terminated by a newline.
+func (s *StreamWriter) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf) == 0 {
+		return nil
+	}
+	err := s.writeLine(s.buf)
+	s.buf = nil
+	return err
+}
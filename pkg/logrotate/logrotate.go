@@ -0,0 +1,243 @@
+package logrotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	backupTimeFormat = "20060102T150405.000"
+	compressSuffix   = ".gz"
+)
+
+// Writer is an io.WriteCloser appending to a log file, rotating it once it
+// reaches MaxSize bytes or gets older than MaxAge. Rotated files are renamed
+// to <path>.<timestamp>, optionally compressed, and only the last MaxBackups
+// of them are kept. It is safe for concurrent use.
+type Writer struct {
+	// Path of the log file.
+	Path string
+	// MaxSize is the size in bytes the log file is rotated at; 0 means no
+	// limit.
+	MaxSize int64
+	// MaxAge is the time the log file is rotated after; 0 means no limit.
+	// The age of a log file that already exists when it is opened is counted
+	// from its last modification time.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated log files to keep; 0 means keep
+	// all of them.
+	MaxBackups int
+	// Compress rotated log files with gzip.
+	Compress bool
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	created time.Time
+	millMu  sync.Mutex
+	millWg  sync.WaitGroup
+	now     func() time.Time
+}
+
+func (w *Writer) timeNow() time.Time {
+	if w.now != nil {
+		return w.now()
+	}
+	return time.Now()
+}
+
+// open opens the log file, rotating it first if it is already over the
+// limits.
+func (w *Writer) open(n int64) error {
+	w.size = 0
+	w.created = w.timeNow()
+	info, err := os.Stat(w.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("checking %s: %v", w.Path, err)
+	}
+	if err == nil && info.Size() > 0 {
+		w.size = info.Size()
+		w.created = info.ModTime()
+		if w.needsRotation(n) {
+			return w.rotate()
+		}
+	}
+	f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", w.Path, err)
+	}
+	w.file = f
+	return nil
+}
+
+func (w *Writer) needsRotation(n int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.MaxSize > 0 && w.size+n > w.MaxSize {
+		return true
+	}
+	if w.MaxAge > 0 && w.timeNow().Sub(w.created) >= w.MaxAge {
+		return true
+	}
+	return false
+}
+
+// rotate moves the current log file out of the way and opens a new one.
+func (w *Writer) rotate() error {
+	if w.file != nil {
+		err := w.file.Close()
+		w.file = nil
+		if err != nil {
+			return fmt.Errorf("closing %s: %v", w.Path, err)
+		}
+	}
+	backup := w.Path + "." + w.timeNow().UTC().Format(backupTimeFormat)
+	err := os.Rename(w.Path, backup)
+	rotated := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("rotating %s: %v", w.Path, err)
+	}
+	f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", w.Path, err)
+	}
+	w.file = f
+	w.size = 0
+	w.created = w.timeNow()
+	if !rotated {
+		return nil
+	}
+	klog.V(2).Infof("rotated %s to %s", w.Path, backup)
+	w.millWg.Add(1)
+	go func() {
+		defer w.millWg.Done()
+		w.mill(backup)
+	}()
+	return nil
+}
+
+// mill compresses a freshly rotated backup, and removes old ones.
+func (w *Writer) mill(backup string) {
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+	if w.Compress {
+		err := compressFile(backup)
+		if err != nil {
+			klog.Warningf("compressing %s: %v", backup, err)
+		}
+	}
+	if w.MaxBackups <= 0 {
+		return
+	}
+	backups, err := w.backups()
+	if err != nil {
+		klog.Warningf("listing backups of %s: %v", w.Path, err)
+		return
+	}
+	for len(backups) > w.MaxBackups {
+		klog.V(2).Infof("removing old log file %s", backups[0])
+		err := os.Remove(backups[0])
+		if err != nil && !os.IsNotExist(err) {
+			klog.Warningf("removing %s: %v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// backups returns the rotated log files, oldest first.
+func (w *Writer) backups() ([]string, error) {
+	matches, err := filepath.Glob(w.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	prefix := w.Path + "."
+	backups := make([]string, 0, len(matches))
+	for _, m := range matches {
+		ts := strings.TrimSuffix(strings.TrimPrefix(m, prefix), compressSuffix)
+		if _, err := time.Parse(backupTimeFormat, ts); err != nil {
+			continue
+		}
+		backups = append(backups, m)
+	}
+	// The timestamp format sorts chronologically.
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(
+		path+compressSuffix, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + compressSuffix)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Write writes p to the log file, rotating it first if needed.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := int64(len(p))
+	if w.file == nil {
+		err := w.open(n)
+		if err != nil {
+			return 0, err
+		}
+	} else if w.needsRotation(n) {
+		err := w.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	written, err := w.file.Write(p)
+	w.size += int64(written)
+	return written, err
+}
+
+// Rotate rotates the log file right away.
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+// Close closes the log file, and waits for compressing rotated log files to
+// finish.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+	w.millWg.Wait()
+	return err
+}
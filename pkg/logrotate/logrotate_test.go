@@ -0,0 +1,109 @@
+package logrotate
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWriter(t *testing.T) (*Writer, *time.Time, func()) {
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	w := &Writer{
+		Path: filepath.Join(dir, "itzo.log"),
+		now: func() time.Time {
+			return now
+		},
+	}
+	return w, &now, func() {
+		w.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWriterMaxSize(t *testing.T) {
+	w, now, cleanup := newTestWriter(t)
+	defer cleanup()
+	w.MaxSize = 10
+	w.MaxBackups = 2
+	for i := 0; i < 4; i++ {
+		_, err := w.Write([]byte("12345678\n"))
+		assert.NoError(t, err)
+		*now = now.Add(time.Second)
+	}
+	assert.NoError(t, w.Close())
+	backups, err := w.backups()
+	assert.NoError(t, err)
+	assert.Len(t, backups, 2)
+	assert.Equal(t, w.Path+".20200701T120003.000", backups[1])
+	contents, err := ioutil.ReadFile(w.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678\n", string(contents))
+}
+
+func TestWriterMaxAge(t *testing.T) {
+	w, now, cleanup := newTestWriter(t)
+	defer cleanup()
+	w.MaxAge = time.Hour
+	_, err := w.Write([]byte("first\n"))
+	assert.NoError(t, err)
+	*now = now.Add(30 * time.Minute)
+	_, err = w.Write([]byte("second\n"))
+	assert.NoError(t, err)
+	*now = now.Add(30 * time.Minute)
+	_, err = w.Write([]byte("third\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	backups, err := w.backups()
+	assert.NoError(t, err)
+	assert.Len(t, backups, 1)
+	contents, err := ioutil.ReadFile(backups[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(contents))
+}
+
+func TestWriterExistingFile(t *testing.T) {
+	w, now, cleanup := newTestWriter(t)
+	defer cleanup()
+	w.MaxAge = time.Hour
+	err := ioutil.WriteFile(w.Path, []byte("old\n"), 0600)
+	assert.NoError(t, err)
+	mtime := now.Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(w.Path, mtime, mtime))
+	_, err = w.Write([]byte("new\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	backups, err := w.backups()
+	assert.NoError(t, err)
+	assert.Len(t, backups, 1)
+	contents, err := ioutil.ReadFile(w.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, "new\n", string(contents))
+}
+
+func TestWriterCompress(t *testing.T) {
+	w, _, cleanup := newTestWriter(t)
+	defer cleanup()
+	w.Compress = true
+	_, err := w.Write([]byte("compressed\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Rotate())
+	assert.NoError(t, w.Close())
+	backups, err := w.backups()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{w.Path + ".20200701T120000.000.gz"}, backups)
+	f, err := os.Open(backups[0])
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	contents, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, "compressed\n", string(contents))
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "itzo_launcher"
+
+var (
+	DownloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "download_bytes_total",
+		Help:      "Bytes downloaded, by URL scheme.",
+	}, []string{"scheme"})
+	DownloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Time it took to download a file, including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"scheme", "result"})
+	DownloadRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "download_retries_total",
+		Help:      "Number of times a download was retried.",
+	})
+	AddonRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "addon_runs_total",
+		Help:      "Addon runs, by addon name and result.",
+	}, []string{"addon", "result"})
+	ParameterSource = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "parameter_source",
+		Help:      "Where the itzo parameters came from, e.g. ssm or a cloud-init datasource type; 1 for the source used.",
+	}, []string{"source"})
+	ItzoRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "itzo_restarts_total",
+		Help:      "Number of times itzo was restarted.",
+	})
+	itzoUptime = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "itzo_uptime_seconds",
+		Help:      "Time since itzo was last started; 0 if it's not running.",
+	}, func() float64 {
+		itzoMu.Lock()
+		defer itzoMu.Unlock()
+		if itzoStarted.IsZero() {
+			return 0
+		}
+		return time.Since(itzoStarted).Seconds()
+	})
+
+	itzoMu      sync.Mutex
+	itzoStarted time.Time
+	itzoRuns    int
+)
+
+func init() {
+	prometheus.MustRegister(
+		DownloadBytes,
+		DownloadDuration,
+		DownloadRetries,
+		AddonRuns,
+		ParameterSource,
+		ItzoRestarts,
+		itzoUptime,
+	)
+}
+
+// Result returns the result label for err.
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// SetParameterSource records where parameters were retrieved from.
+func SetParameterSource(source string) {
+	ParameterSource.Reset()
+	ParameterSource.WithLabelValues(source).Set(1)
+}
+
+// ItzoStarting is called each time itzo is started; starts after the first
+// one are counted as restarts.
+func ItzoStarting() {
+	itzoMu.Lock()
+	defer itzoMu.Unlock()
+	if itzoRuns > 0 {
+		ItzoRestarts.Inc()
+	}
+	itzoRuns++
+	itzoStarted = time.Now()
+}
+
+// ItzoExited is called when itzo exits.
+func ItzoExited() {
+	itzoMu.Lock()
+	defer itzoMu.Unlock()
+	itzoStarted = time.Time{}
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItzoRestarts(t *testing.T) {
+	restarts := testutil.ToFloat64(ItzoRestarts)
+	ItzoStarting()
+	assert.Equal(t, restarts, testutil.ToFloat64(ItzoRestarts))
+	assert.True(t, testutil.ToFloat64(itzoUptime) > 0)
+	ItzoExited()
+	assert.Equal(t, 0.0, testutil.ToFloat64(itzoUptime))
+	ItzoStarting()
+	assert.Equal(t, restarts+1, testutil.ToFloat64(ItzoRestarts))
+}
+
+func TestParameterSource(t *testing.T) {
+	SetParameterSource("ec2-metadata-service")
+	SetParameterSource("ssm")
+	assert.Equal(t, 1, testutil.CollectAndCount(ParameterSource))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ParameterSource.WithLabelValues("ssm")))
+}
+
+func TestHandler(t *testing.T) {
+	AddonRuns.WithLabelValues("nfs", Result(errors.New("failed"))).Inc()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `itzo_launcher_addon_runs_total{addon="nfs",result="failure"} 1`)
+}
@@ -6,41 +6,29 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
-	"gopkg.in/yaml.v2"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/elotl/itzo-launcher/pkg/imds"
+	"github.com/elotl/itzo-launcher/pkg/parameters"
 	"k8s.io/klog"
 )
 
-const (
-	awsDetectionTimeout  = 1 * time.Second
-	awsTimeout           = 10 * time.Second
-	ssmMaxChunks         = 10
-	ssmParameterBaseName = "config"
-)
-
+// AWSParameters retrieves instance parameters from SSM Parameter Store, from
+// under <base>/<instance ID>.
 type AWSParameters struct {
-	base string
-	ssm  *ssm.SSM
+	base       string
+	config     *aws.Config
+	instanceID string
+	ssm        ssmiface.SSMAPI
 }
 
 func detectRegion() string {
-	session, err := session.NewSession()
-	if err != nil {
-		klog.Warningf("creating session to autodetect AWS region: %v", err)
-		return ""
-	}
-	client := ec2metadata.New(session)
-
-	ctx, cancel := context.WithTimeout(aws.BackgroundContext(), awsDetectionTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), parameters.DetectionTimeout)
 	defer cancel()
-	region, err := client.RegionWithContext(ctx)
+	region, err := imds.Default().Region(ctx)
 	if err != nil {
 		klog.Warningf("trying to autodetect AWS region: %v", err)
 		return ""
@@ -51,16 +39,9 @@ func detectRegion() string {
 }
 
 func detectInstanceID() string {
-	session, err := session.NewSession()
-	if err != nil {
-		klog.Warningf("creating session to autodetect AWS region: %v", err)
-		return ""
-	}
-	client := ec2metadata.New(session)
-
-	ctx, cancel := context.WithTimeout(aws.BackgroundContext(), awsDetectionTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), parameters.DetectionTimeout)
 	defer cancel()
-	instanceID, err := client.GetMetadataWithContext(ctx, "instance-id")
+	instanceID, err := imds.Default().InstanceID(ctx)
 	if err != nil {
 		klog.Warningf("trying to autodetect AWS instance ID: %v", err)
 		return ""
@@ -83,129 +64,87 @@ func getAWSConfig() (*aws.Config, error) {
 	}
 
 	httpClient := &http.Client{
-		Timeout: awsTimeout,
+		Timeout: parameters.Timeout,
 	}
 	config := aws.NewConfig().WithHTTPClient(httpClient).WithRegion(region)
 	return config, nil
 }
 
-func NewAWSParameters(base string, config *aws.Config) (*AWSParameters, error) {
-	if config == nil {
-		var err error
-		config, err = getAWSConfig()
-		if err != nil {
-			return nil, fmt.Errorf("creating AWS config: %v", err)
-		}
-	}
-
-	instanceID := detectInstanceID()
-	if instanceID == "" {
-		return nil, fmt.Errorf("failed to detect AWS instance ID, not running on AWS?")
-	}
-
-	sess, err := session.NewSession()
-	if err != nil {
-		return nil, err
+// NewAWSParameters creates a provider for SSM parameters under base. If
+// config is nil, the region is detected via the EC2 metadata service.
+func NewAWSParameters(base string, config *aws.Config) *AWSParameters {
+	return &AWSParameters{
+		base:   base,
+		config: config,
 	}
-	ssmClient := ssm.New(sess, config)
+}
 
-	return &AWSParameters{
-		base: filepath.Join(base, instanceID),
-		ssm:  ssmClient,
-	}, nil
+func (a *AWSParameters) Name() string {
+	return "aws-ssm"
 }
 
-func unmarshalParameters(params map[string]string) (map[string]string, error) {
-	// This is either one single SSM parameter if it fit (<=4096 bytes), or
-	// chunks of the serialized config e.g. "config-0", "config-1", etc. We
-	// need to assemble the chunks in order, de-serialize and re-create the
-	// original config map.
-	if len(params) == 0 {
-		return nil, fmt.Errorf("got no SSM parameters")
+func (a *AWSParameters) Detect() bool {
+	if a.instanceID == "" {
+		a.instanceID = detectInstanceID()
 	}
+	return a.instanceID != ""
+}
 
-	// The whole map in one parameter.
-	if len(params) == 1 {
-		for k, v := range params {
-			configMap := make(map[string]string)
-			klog.V(2).Infof("unmarshaling SSM parameter %q", k)
-			err := yaml.Unmarshal([]byte(v), &configMap)
-			if err != nil {
-				return nil, err
-			}
-			return configMap, nil
-		}
+func (a *AWSParameters) init() error {
+	if a.ssm != nil {
+		return nil
 	}
-
-	// Multiple chunks.
-	paramList := make([]string, len(params))
-	for k, v := range params {
-		parts := strings.SplitN(k, "-", 2)
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("invalid SSM parameter chunk key: %s", k)
-		}
-		n, err := strconv.ParseUint(parts[1], 10, 32)
+	if a.config == nil {
+		config, err := getAWSConfig()
 		if err != nil {
-			return nil, err
-		}
-		if int(n) >= len(paramList) {
-			return nil, fmt.Errorf("invalid SSM parameter chunk key: %s", k)
+			return fmt.Errorf("creating AWS config: %v", err)
 		}
-		paramList[n] = v
+		a.config = config
 	}
-	yml := ""
-	for i := range paramList {
-		yml = yml + paramList[i]
+	if !a.Detect() {
+		return fmt.Errorf("failed to detect AWS instance ID, not running on AWS?")
 	}
-	configMap := make(map[string]string)
-	klog.V(2).Infof("unmarshaling %d SSM parameter chunks, %d bytes", len(paramList), len(yml))
-	err := yaml.Unmarshal([]byte(yml), &configMap)
+	sess, err := session.NewSession()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return configMap, nil
+	a.ssm = ssm.New(sess, a.config)
+	return nil
 }
 
-func (a *AWSParameters) getParameter(name string) string {
-	path := filepath.Join(a.base, name)
-	in := &ssm.GetParameterInput{
-		Name:           aws.String(path),
+// listParameters returns the config parameters under the base path of the
+// instance, paging through all of them.
+func (a *AWSParameters) listParameters() (map[string]string, error) {
+	path := filepath.Join(a.base, a.instanceID) + "/"
+	in := &ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
 		WithDecryption: aws.Bool(true),
 	}
-	out, err := a.ssm.GetParameter(in)
+	params := make(map[string]string)
+	pages := 0
+	err := a.ssm.GetParametersByPathPages(in,
+		func(out *ssm.GetParametersByPathOutput, lastPage bool) bool {
+			pages++
+			for _, p := range out.Parameters {
+				params[aws.StringValue(p.Name)] = aws.StringValue(p.Value)
+			}
+			return true
+		})
 	if err != nil {
-		klog.V(2).Infof("getting SSM parameter %s: %v", name, err)
-		return ""
+		return nil, fmt.Errorf("listing SSM parameters under %s: %v", path, err)
 	}
-	if out.Parameter == nil {
-		klog.V(2).Infof("got nil SSM parameter value for %s", name)
-		return ""
-	}
-	return aws.StringValue(out.Parameter.Value)
+	klog.V(2).Infof("got %d SSM parameters in %d page(s)", len(params), pages)
+	return parameters.ConfigParameters(params, path), nil
 }
 
 func (a *AWSParameters) GetAllParameters() (map[string]string, error) {
-	params := make(map[string]string)
-
-	name := ssmParameterBaseName
-	value := a.getParameter(name)
-	if value != "" {
-		params[name] = value
-	}
-
-	for i := 0; i < ssmMaxChunks; i++ {
-		name = fmt.Sprintf("%s-%d", ssmParameterBaseName, i)
-		value = a.getParameter(name)
-		if value == "" {
-			break
-		}
-		params[name] = value
+	err := a.init()
+	if err != nil {
+		return nil, err
 	}
-
-	configMap, err := unmarshalParameters(params)
+	params, err := a.listParameters()
 	if err != nil {
 		return nil, err
 	}
-
-	return configMap, nil
+	return parameters.Unmarshal(params)
 }
@@ -1,86 +1,68 @@
 package aws
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/stretchr/testify/assert"
 )
 
-//func unmarshalParameters(params map[string]string) (map[string]string, error) {
-func TestUnmarshalParameters(t *testing.T) {
-	testCases := []struct {
-		inputMap  map[string]string
-		outputMap map[string]string
-		failure   bool
-	}{
-		{
-			// Basic case, one key that contains the whole map.
-			inputMap: map[string]string{
-				"config": "param1: value1\nparam2: value2\n",
-			},
-			outputMap: map[string]string{
-				"param1": "value1",
-				"param2": "value2",
-			},
-			failure: false,
-		},
-		{
-			// Map split into two chunks.
-			inputMap: map[string]string{
-				"config-0": "param1: value1\nparam2: val",
-				"config-1": "ue2\nparam3: value3\n",
-			},
-			outputMap: map[string]string{
-				"param1": "value1",
-				"param2": "value2",
-				"param3": "value3",
-			},
-			failure: false,
-		},
-		{
-			// Not a simple map.
-			inputMap: map[string]string{
-				"config": "list1:\n- elem1\n- elem2\nparam2: value2\n",
-			},
-			outputMap: nil,
-			failure:   true,
-		},
-		{
-			// Key without index.
-			inputMap: map[string]string{
-				"config":   "param1: value1\n",
-				"config-0": "param1: value1\n",
-				"config-1": "param2: value2\n",
-			},
-			outputMap: nil,
-			failure:   true,
-		},
-		{
-			// Key with invalid index.
-			inputMap: map[string]string{
-				"config-0": "param1: value1\n",
-				"config-2": "param2: value2\n",
-			},
-			outputMap: nil,
-			failure:   true,
-		},
-		{
-			// Invalid input.
-			inputMap: map[string]string{
-				"config": "an escaped \\' single quote",
-			},
-			outputMap: nil,
-			failure:   true,
-		},
-	}
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	params   map[string]string
+	pageSize int
+}
 
-	for _, tc := range testCases {
-		output, err := unmarshalParameters(tc.inputMap)
-		if tc.failure {
-			assert.Error(t, err)
-		} else {
-			assert.NoError(t, err)
+func (f *fakeSSM) GetParametersByPathPages(in *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	path := aws.StringValue(in.Path)
+	page := &ssm.GetParametersByPathOutput{}
+	for name, value := range f.params {
+		if !strings.HasPrefix(name, path) {
+			continue
 		}
-		assert.Equal(t, tc.outputMap, output)
+		page.Parameters = append(page.Parameters, &ssm.Parameter{
+			Name:  aws.String(name),
+			Value: aws.String(value),
+		})
+		if len(page.Parameters) == f.pageSize {
+			if !fn(page, false) {
+				return nil
+			}
+			page = &ssm.GetParametersByPathOutput{}
+		}
+	}
+	fn(page, true)
+	return nil
+}
+
+func TestGetAllParameters(t *testing.T) {
+	params := map[string]string{
+		"/kip/cells/i-1234/other": "ignored",
 	}
+	// More than the 10 chunks GetParameter used to be limited to.
+	for i := 0; i < 15; i++ {
+		params[fmt.Sprintf("/kip/cells/i-1234/config-%d", i)] =
+			fmt.Sprintf("param%d: value%d\n", i, i)
+	}
+	a := &AWSParameters{
+		base:       "/kip/cells",
+		instanceID: "i-1234",
+		ssm: &fakeSSM{
+			params:   params,
+			pageSize: 4,
+		},
+	}
+	configMap, err := a.GetAllParameters()
+	assert.NoError(t, err)
+	assert.Len(t, configMap, 15)
+	assert.Equal(t, "value14", configMap["param14"])
+
+	delete(params, "/kip/cells/i-1234/config-7")
+	_, err = a.GetAllParameters()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing parameter chunk(s) 7")
 }
@@ -0,0 +1,117 @@
+package azure
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/elotl/itzo-launcher/pkg/parameters"
+	"k8s.io/klog"
+)
+
+const (
+	imdsEndpoint   = "http://169.254.169.254/metadata/instance/compute"
+	imdsAPIVersion = "2021-01-01"
+	// TagPrefix is the prefix of the instance tags holding the config, e.g.
+	// "kip-config", or "kip-config-0", "kip-config-1", etc. if it's split into
+	// chunks. Tags are only used if there is no user data.
+	TagPrefix = "kip-"
+)
+
+// AzureParameters retrieves instance parameters from the user data of an
+// Azure VM, or if it has none, from its tags, via the instance metadata
+// service.
+type AzureParameters struct {
+	endpoint string
+}
+
+func NewAzureParameters() *AzureParameters {
+	return &AzureParameters{
+		endpoint: imdsEndpoint,
+	}
+}
+
+func (a *AzureParameters) Name() string {
+	return "azure-imds"
+}
+
+func (a *AzureParameters) url(path, format string) string {
+	url := fmt.Sprintf("%s/%s?api-version=%s", a.endpoint, path, imdsAPIVersion)
+	if format != "" {
+		url += "&format=" + format
+	}
+	return url
+}
+
+func metadataHeader() http.Header {
+	header := http.Header{}
+	header.Set("Metadata", "true")
+	return header
+}
+
+func (a *AzureParameters) Detect() bool {
+	vmID, _, err := parameters.GetMetadata(
+		a.url("vmId", "text"), metadataHeader(), parameters.DetectionTimeout)
+	if err != nil {
+		klog.V(2).Infof("trying to detect Azure VM: %v", err)
+		return false
+	}
+	klog.V(2).Infof("detected Azure VM ID: %q", vmID)
+	return true
+}
+
+func (a *AzureParameters) getUserData() (string, error) {
+	body, _, err := parameters.GetMetadata(
+		a.url("userData", "text"), metadataHeader(), parameters.Timeout)
+	if err != nil {
+		return "", fmt.Errorf("getting user data: %v", err)
+	}
+	userData, err := base64.StdEncoding.DecodeString(
+		strings.TrimSpace(string(body)))
+	if err != nil {
+		return "", fmt.Errorf("decoding user data: %v", err)
+	}
+	return string(userData), nil
+}
+
+func (a *AzureParameters) getTags() (map[string]string, error) {
+	body, _, err := parameters.GetMetadata(
+		a.url("tagsList", ""), metadataHeader(), parameters.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("getting tags: %v", err)
+	}
+	tagList := []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}{}
+	err = json.Unmarshal(body, &tagList)
+	if err != nil {
+		return nil, fmt.Errorf("decoding tags: %v", err)
+	}
+	tags := make(map[string]string, len(tagList))
+	for _, tag := range tagList {
+		tags[tag.Name] = tag.Value
+	}
+	return tags, nil
+}
+
+func (a *AzureParameters) GetAllParameters() (map[string]string, error) {
+	userData, err := a.getUserData()
+	if err != nil {
+		return nil, err
+	}
+	if userData != "" {
+		klog.V(2).Infof("using config from Azure user data")
+		return parameters.Unmarshal(map[string]string{
+			parameters.BaseName: userData,
+		})
+	}
+	tags, err := a.getTags()
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("using config from Azure tags")
+	return parameters.Unmarshal(parameters.ConfigParameters(tags, TagPrefix))
+}
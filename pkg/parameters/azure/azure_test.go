@@ -0,0 +1,65 @@
+package azure
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(userData string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata") != "true" ||
+				r.URL.Query().Get("api-version") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			switch r.URL.Path {
+			case "/vmId":
+				w.Write([]byte("02aab8a4-74ef-476e-8182-f6d2ba4166a6"))
+			case "/userData":
+				w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(userData))))
+			case "/tagsList":
+				w.Write([]byte(`[
+					{"name": "env", "value": "dev"},
+					{"name": "kip-config-0", "value": "itzoVersion: 1.2"},
+					{"name": "kip-config-1", "value": ".3\n"}
+				]`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+}
+
+func TestAzureParametersUserData(t *testing.T) {
+	server := newTestServer("itzoVersion: 1.2.4\n")
+	defer server.Close()
+	a := &AzureParameters{
+		endpoint: server.URL,
+	}
+	assert.True(t, a.Detect())
+	params, err := a.GetAllParameters()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"itzoVersion": "1.2.4"}, params)
+}
+
+func TestAzureParametersTags(t *testing.T) {
+	server := newTestServer("")
+	defer server.Close()
+	a := &AzureParameters{
+		endpoint: server.URL,
+	}
+	params, err := a.GetAllParameters()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"itzoVersion": "1.2.3"}, params)
+}
+
+func TestAzureParametersNotAzure(t *testing.T) {
+	a := &AzureParameters{
+		endpoint: "http://127.0.0.1:1",
+	}
+	assert.False(t, a.Detect())
+}
@@ -0,0 +1,71 @@
+package gcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/elotl/itzo-launcher/pkg/parameters"
+	"k8s.io/klog"
+)
+
+const (
+	metadataEndpoint = "http://metadata.google.internal/computeMetadata/v1"
+	// AttributePrefix is the prefix of the instance metadata attributes
+	// holding the config, e.g. "kip-config", or "kip-config-0", "kip-config-1",
+	// etc. if it's split into chunks.
+	AttributePrefix = "kip-"
+)
+
+// GCPParameters retrieves instance parameters from the custom metadata
+// attributes of a GCE instance.
+type GCPParameters struct {
+	endpoint string
+}
+
+func NewGCPParameters() *GCPParameters {
+	return &GCPParameters{
+		endpoint: metadataEndpoint,
+	}
+}
+
+func (g *GCPParameters) Name() string {
+	return "gce-metadata"
+}
+
+func (g *GCPParameters) get(path string) ([]byte, http.Header, error) {
+	header := http.Header{}
+	header.Set("Metadata-Flavor", "Google")
+	return parameters.GetMetadata(g.endpoint+path, header, parameters.Timeout)
+}
+
+func (g *GCPParameters) Detect() bool {
+	header := http.Header{}
+	header.Set("Metadata-Flavor", "Google")
+	id, respHeader, err := parameters.GetMetadata(
+		g.endpoint+"/instance/id", header, parameters.DetectionTimeout)
+	if err != nil {
+		klog.V(2).Infof("trying to detect GCE instance: %v", err)
+		return false
+	}
+	if respHeader.Get("Metadata-Flavor") != "Google" {
+		klog.V(2).Infof("unexpected response from GCE metadata server")
+		return false
+	}
+	klog.V(2).Infof("detected GCE instance ID: %q", id)
+	return true
+}
+
+func (g *GCPParameters) GetAllParameters() (map[string]string, error) {
+	body, _, err := g.get("/instance/attributes/?recursive=true")
+	if err != nil {
+		return nil, fmt.Errorf("getting instance attributes: %v", err)
+	}
+	attributes := make(map[string]string)
+	err = json.Unmarshal(body, &attributes)
+	if err != nil {
+		return nil, fmt.Errorf("decoding instance attributes: %v", err)
+	}
+	params := parameters.ConfigParameters(attributes, AttributePrefix)
+	return parameters.Unmarshal(params)
+}
@@ -0,0 +1,58 @@
+package gcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(flavor string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Metadata-Flavor", flavor)
+			switch r.URL.Path {
+			case "/instance/id":
+				w.Write([]byte("1234567890"))
+			case "/instance/attributes/":
+				w.Write([]byte(`{
+					"ssh-keys": "user:ssh-rsa AAAA",
+					"kip-config-0": "itzoVersion: 1.2.3\nitzoFlag--v: \"",
+					"kip-config-1": "3\"\n"
+				}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+}
+
+func TestGCPParameters(t *testing.T) {
+	server := newTestServer("Google")
+	defer server.Close()
+	g := &GCPParameters{
+		endpoint: server.URL,
+	}
+	assert.True(t, g.Detect())
+	params, err := g.GetAllParameters()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"itzoVersion": "1.2.3",
+		"itzoFlag--v": "3",
+	}, params)
+}
+
+func TestGCPParametersNotGCE(t *testing.T) {
+	server := newTestServer("")
+	defer server.Close()
+	g := &GCPParameters{
+		endpoint: server.URL,
+	}
+	assert.False(t, g.Detect())
+	g.endpoint = "http://127.0.0.1:1"
+	assert.False(t, g.Detect())
+}
@@ -0,0 +1,96 @@
+package parameters
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ManifestName is the name of the optional parameter describing the
+	// config, for verifying it after its chunks are reassembled.
+	ManifestName = BaseName + "-manifest"
+	// EncodingGzipBase64 means the config is gzip compressed, then base64
+	// encoded.
+	EncodingGzipBase64 = "gzip+base64"
+)
+
+// Manifest describes the config stored in parameters. Length and SHA256 are
+// of the reassembled payload, before it's decoded.
+type Manifest struct {
+	Chunks   int    `yaml:"chunks"`
+	Length   int    `yaml:"length"`
+	SHA256   string `yaml:"sha256"`
+	Encoding string `yaml:"encoding"`
+}
+
+// splitManifest removes the manifest from params, and parses it if there is
+// one.
+func splitManifest(params map[string]string) (map[string]string, *Manifest, error) {
+	value, ok := params[ManifestName]
+	if !ok {
+		return params, nil, nil
+	}
+	rest := make(map[string]string, len(params)-1)
+	for k, v := range params {
+		if k != ManifestName {
+			rest[k] = v
+		}
+	}
+	manifest := &Manifest{}
+	err := yaml.UnmarshalStrict([]byte(value), manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %v", ManifestName, err)
+	}
+	switch manifest.Encoding {
+	case "", EncodingGzipBase64:
+	default:
+		return nil, nil, fmt.Errorf("unsupported encoding %q in %s",
+			manifest.Encoding, ManifestName)
+	}
+	return rest, manifest, nil
+}
+
+// verify checks payload against the manifest, and returns it decoded.
+func (m *Manifest) verify(payload string, chunks int) (string, error) {
+	if m.Chunks > 0 && m.Chunks != chunks {
+		return "", fmt.Errorf("config has %d chunk(s), %s expects %d",
+			chunks, ManifestName, m.Chunks)
+	}
+	if m.Length > 0 && m.Length != len(payload) {
+		return "", fmt.Errorf("config is %d bytes, %s expects %d",
+			len(payload), ManifestName, m.Length)
+	}
+	if m.SHA256 != "" {
+		sum := sha256.Sum256([]byte(payload))
+		digest := hex.EncodeToString(sum[:])
+		if !strings.EqualFold(digest, m.SHA256) {
+			return "", fmt.Errorf("config has SHA-256 digest %s, %s expects %s",
+				digest, ManifestName, m.SHA256)
+		}
+	}
+	if m.Encoding != EncodingGzipBase64 {
+		return payload, nil
+	}
+	compressed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("decoding config: %v", err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", fmt.Errorf("decompressing config: %v", err)
+	}
+	defer r.Close()
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("decompressing config: %v", err)
+	}
+	return string(decoded), nil
+}
@@ -0,0 +1,118 @@
+package parameters
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipBase64(t *testing.T, s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(s))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUnmarshalManifest(t *testing.T) {
+	config := "param1: value1\nparam2: value2\n"
+	encoded := gzipBase64(t, config)
+	half := len(encoded) / 2
+	manifest := func(chunks, length int, digest, encoding string) string {
+		return fmt.Sprintf("chunks: %d\nlength: %d\nsha256: %s\nencoding: %s\n",
+			chunks, length, digest, encoding)
+	}
+	testCases := []struct {
+		params  map[string]string
+		failure bool
+	}{
+		{
+			// Compressed and split into two chunks.
+			params: map[string]string{
+				"config-0":        encoded[:half],
+				"config-1":        encoded[half:],
+				"config-manifest": manifest(2, len(encoded), sha256Hex(encoded), EncodingGzipBase64),
+			},
+		},
+		{
+			// Plain config in one parameter.
+			params: map[string]string{
+				"config":          config,
+				"config-manifest": manifest(1, len(config), sha256Hex(config), ""),
+			},
+		},
+		{
+			// Manifest only with a digest.
+			params: map[string]string{
+				"config":          config,
+				"config-manifest": `{"sha256": "` + sha256Hex(config) + `"}`,
+			},
+		},
+		{
+			// Chunk from an older version of the config.
+			params: map[string]string{
+				"config-0":        encoded[:half],
+				"config-1":        encoded[half:len(encoded)-4] + "AAA=",
+				"config-manifest": manifest(2, len(encoded), sha256Hex(encoded), EncodingGzipBase64),
+			},
+			failure: true,
+		},
+		{
+			// Wrong number of chunks.
+			params: map[string]string{
+				"config-0":        encoded[:half],
+				"config-1":        encoded[half:],
+				"config-manifest": manifest(3, len(encoded), sha256Hex(encoded), EncodingGzipBase64),
+			},
+			failure: true,
+		},
+		{
+			// Wrong length.
+			params: map[string]string{
+				"config":          config,
+				"config-manifest": manifest(1, len(config)+1, sha256Hex(config), ""),
+			},
+			failure: true,
+		},
+		{
+			// Unknown encoding.
+			params: map[string]string{
+				"config":          config,
+				"config-manifest": manifest(1, len(config), sha256Hex(config), "zstd"),
+			},
+			failure: true,
+		},
+		{
+			// Invalid manifest.
+			params: map[string]string{
+				"config":          config,
+				"config-manifest": "chunks: [1]\n",
+			},
+			failure: true,
+		},
+	}
+	for i, tc := range testCases {
+		output, err := Unmarshal(tc.params)
+		if tc.failure {
+			assert.Error(t, err, "test case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "test case %d", i)
+		assert.Equal(t, map[string]string{
+			"param1": "value1",
+			"param2": "value2",
+		}, output, "test case %d", i)
+	}
+}
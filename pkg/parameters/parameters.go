@@ -0,0 +1,215 @@
+package parameters
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog"
+)
+
+const (
+	// DetectionTimeout is the timeout for checking whether the instance is
+	// running on a cloud provider.
+	DetectionTimeout = 1 * time.Second
+	// Timeout is the timeout for retrieving parameters.
+	Timeout = 10 * time.Second
+	// BaseName is the name of the parameter holding the config, or the prefix
+	// of chunks of it, e.g. "config-0", "config-1", etc.
+	BaseName = "config"
+)
+
+// Provider retrieves instance parameters from the metadata service or
+// parameter store of a cloud provider.
+type Provider interface {
+	// Name returns the name of the provider, e.g. "aws-ssm".
+	Name() string
+	// Detect checks whether the instance is running on the cloud of the
+	// provider.
+	Detect() bool
+	// GetAllParameters returns the config map for the instance.
+	GetAllParameters() (map[string]string, error)
+}
+
+// Detect returns the first provider that detects it's running on its cloud.
+func Detect(providers ...Provider) (Provider, error) {
+	names := make([]string, 0, len(providers))
+	for _, p := range providers {
+		klog.V(2).Infof("checking instance parameter provider %s", p.Name())
+		if p.Detect() {
+			klog.V(2).Infof("using instance parameter provider %s", p.Name())
+			return p, nil
+		}
+		names = append(names, p.Name())
+	}
+	return nil, fmt.Errorf("no instance parameter provider detected, tried %s",
+		strings.Join(names, ", "))
+}
+
+// Unmarshal re-creates the config map from parameters. This is either one
+// single parameter if it fit, or chunks of the serialized config e.g.
+// "config-0", "config-1", etc. If there is a "config-manifest" parameter, the
+// reassembled config is verified and decoded according to it.
+func Unmarshal(params map[string]string) (map[string]string, error) {
+	params, manifest, err := splitManifest(params)
+	if err != nil {
+		return nil, err
+	}
+	payload, chunks, err := assemble(params)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		payload, err = manifest.verify(payload, chunks)
+		if err != nil {
+			klog.Errorf("config does not match %s: %v", ManifestName, err)
+			return nil, err
+		}
+	}
+	files := make(map[string]fileContents)
+	klog.V(2).Infof("unmarshaling %d parameter chunk(s), %d bytes", chunks, len(payload))
+	err = yaml.Unmarshal([]byte(payload), &files)
+	if err != nil {
+		return nil, err
+	}
+	configMap := make(map[string]string, len(files))
+	for name, contents := range files {
+		configMap[name] = string(contents)
+	}
+	return configMap, nil
+}
+
+// fileContents is the contents of a config file in the config map. It's
+// usually a string, but structured files like cell_config.yaml can also be
+// given as YAML maps or lists, which are kept as YAML.
+type fileContents string
+
+func (f *fileContents) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal(&s)
+	if err == nil {
+		*f = fileContents(s)
+		return nil
+	}
+	var value interface{}
+	if unmarshal(&value) != nil {
+		return err
+	}
+	buf, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	*f = fileContents(buf)
+	return nil
+}
+
+// assemble returns the serialized config from parameters, and the number of
+// chunks it was split into.
+func assemble(params map[string]string) (string, int, error) {
+	// We need to assemble the chunks in order, de-serialize and re-create the
+	// original config map.
+	if len(params) == 0 {
+		return "", 0, fmt.Errorf("got no parameters")
+	}
+	// The whole map in one parameter. A single chunk other than the first
+	// one goes through the gap check below.
+	if v, ok := params[BaseName]; ok && len(params) == 1 {
+		klog.V(2).Infof("using parameter %q", BaseName)
+		return v, 1, nil
+	}
+	// Multiple chunks.
+	chunks := make(map[int]string, len(params))
+	last := -1
+	for k, v := range params {
+		parts := strings.SplitN(k, "-", 2)
+		if len(parts) < 2 {
+			return "", 0, fmt.Errorf("invalid parameter chunk key: %s", k)
+		}
+		n, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return "", 0, err
+		}
+		if _, ok := chunks[int(n)]; ok {
+			return "", 0, fmt.Errorf("duplicate parameter chunk key: %s", k)
+		}
+		chunks[int(n)] = v
+		if int(n) > last {
+			last = int(n)
+		}
+	}
+	missing := make([]string, 0)
+	for i := 0; i <= last; i++ {
+		if _, ok := chunks[i]; !ok {
+			missing = append(missing, strconv.Itoa(i))
+		}
+	}
+	if len(missing) > 0 {
+		return "", 0, fmt.Errorf("missing parameter chunk(s) %s of %d",
+			strings.Join(missing, ", "), last+1)
+	}
+	var payload strings.Builder
+	for i := 0; i <= last; i++ {
+		payload.WriteString(chunks[i])
+	}
+	return payload.String(), len(chunks), nil
+}
+
+// IsConfigParameter checks whether name is the config parameter or a chunk
+// of it.
+func IsConfigParameter(name string) bool {
+	// This also matches ManifestName.
+	return name == BaseName || strings.HasPrefix(name, BaseName+"-")
+}
+
+// ConfigParameters returns the config parameters, or chunks of it, among
+// params with names starting with prefix. The prefix is removed from the
+// names.
+func ConfigParameters(params map[string]string, prefix string) map[string]string {
+	filtered := make(map[string]string)
+	for k, v := range params {
+		name := strings.TrimPrefix(k, prefix)
+		if strings.HasPrefix(k, prefix) && IsConfigParameter(name) {
+			filtered[name] = v
+		}
+	}
+	return filtered
+}
+
+// GetMetadata fetches url from a metadata service, with header set on the
+// request. A nil response header is returned with the error if the request
+// failed.
+func GetMetadata(url string, header http.Header, timeout time.Duration) ([]byte, http.Header, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating request for %s: %v", url, err)
+	}
+	for k, values := range header {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	// Metadata services are link-local, never use a proxy.
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: nil},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %v", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.Header, fmt.Errorf("getting %s: %s", url, resp.Status)
+	}
+	return body, resp.Header, nil
+}
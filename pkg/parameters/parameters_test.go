@@ -0,0 +1,154 @@
+package parameters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalParameters(t *testing.T) {
+	testCases := []struct {
+		inputMap  map[string]string
+		outputMap map[string]string
+		failure   bool
+	}{
+		{
+			// Basic case, one key that contains the whole map.
+			inputMap: map[string]string{
+				"config": "param1: value1\nparam2: value2\n",
+			},
+			outputMap: map[string]string{
+				"param1": "value1",
+				"param2": "value2",
+			},
+			failure: false,
+		},
+		{
+			// Map split into two chunks.
+			inputMap: map[string]string{
+				"config-0": "param1: value1\nparam2: val",
+				"config-1": "ue2\nparam3: value3\n",
+			},
+			outputMap: map[string]string{
+				"param1": "value1",
+				"param2": "value2",
+				"param3": "value3",
+			},
+			failure: false,
+		},
+		{
+			// Structured files are kept as YAML.
+			inputMap: map[string]string{
+				"config": "list1:\n- elem1\n- elem2\ncell_config.yaml:\n  itzo:\n    flags:\n      v: 2\nparam2: value2\n",
+			},
+			outputMap: map[string]string{
+				"list1":            "- elem1\n- elem2\n",
+				"cell_config.yaml": "itzo:\n  flags:\n    v: 2\n",
+				"param2":           "value2",
+			},
+			failure: false,
+		},
+		{
+			// Key without index.
+			inputMap: map[string]string{
+				"config":   "param1: value1\n",
+				"config-0": "param1: value1\n",
+				"config-1": "param2: value2\n",
+			},
+			outputMap: nil,
+			failure:   true,
+		},
+		{
+			// Key with invalid index.
+			inputMap: map[string]string{
+				"config-0": "param1: value1\n",
+				"config-2": "param2: value2\n",
+			},
+			outputMap: nil,
+			failure:   true,
+		},
+		{
+			// Gap in the chunks.
+			inputMap: map[string]string{
+				"config-0": "param1: value1\n",
+				"config-1": "param2: value2\n",
+				"config-3": "param3: value3\n",
+			},
+			outputMap: nil,
+			failure:   true,
+		},
+		{
+			// Only one chunk, but not the first one.
+			inputMap: map[string]string{
+				"config-1": "param1: value1\n",
+			},
+			outputMap: nil,
+			failure:   true,
+		},
+		{
+			// Only the first chunk.
+			inputMap: map[string]string{
+				"config-0": "param1: value1\n",
+			},
+			outputMap: map[string]string{
+				"param1": "value1",
+			},
+			failure: false,
+		},
+		{
+			// Duplicate chunk index.
+			inputMap: map[string]string{
+				"config-1":  "param1: value1\n",
+				"config-01": "param2: value2\n",
+			},
+			outputMap: nil,
+			failure:   true,
+		},
+		{
+			// Invalid input.
+			inputMap: map[string]string{
+				"config": "an escaped \\' single quote",
+			},
+			outputMap: nil,
+			failure:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		output, err := Unmarshal(tc.inputMap)
+		if tc.failure {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, tc.outputMap, output)
+	}
+}
+
+type fakeProvider struct {
+	name     string
+	detected bool
+}
+
+func (f *fakeProvider) Name() string {
+	return f.name
+}
+
+func (f *fakeProvider) Detect() bool {
+	return f.detected
+}
+
+func (f *fakeProvider) GetAllParameters() (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func TestDetect(t *testing.T) {
+	first := &fakeProvider{name: "first"}
+	second := &fakeProvider{name: "second", detected: true}
+	third := &fakeProvider{name: "third", detected: true}
+	p, err := Detect(first, second, third)
+	assert.NoError(t, err)
+	assert.Equal(t, second, p)
+	_, err = Detect(first)
+	assert.Error(t, err)
+}
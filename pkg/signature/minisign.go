@@ -0,0 +1,176 @@
+package signature
+
+// Verification of minisign (https://jedisct1.github.io/minisign/) detached
+// signatures. Both legacy signatures ("Ed", signing the whole message) and
+// prehashed signatures ("ED", signing the BLAKE2b-512 hash of the message) are
+// supported.
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	keyIDSize              = 8
+	algorithmLegacy        = "Ed"
+	algorithmPrehashed     = "ED"
+	untrustedCommentPrefix = "untrusted comment:"
+	trustedCommentPrefix   = "trusted comment: "
+	encodedPublicKeySize   = 2 + keyIDSize + ed25519.PublicKeySize
+	encodedSignatureSize   = 2 + keyIDSize + ed25519.SignatureSize
+	maxSignatureFileSize   = 64 * 1024
+)
+
+type PublicKey struct {
+	keyID [keyIDSize]byte
+	key   ed25519.PublicKey
+}
+
+type Signature struct {
+	algorithm       string
+	keyID           [keyIDSize]byte
+	signature       []byte
+	trustedComment  string
+	globalSignature []byte
+}
+
+// dataLines returns the non-empty lines of contents, skipping untrusted
+// comments.
+func dataLines(contents string) []string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, untrustedCommentPrefix) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// ParsePublicKey parses a minisign public key. Both the contents of a public
+// key file and the bare base64 encoded key are accepted.
+func ParsePublicKey(contents string) (*PublicKey, error) {
+	lines := dataLines(contents)
+	if len(lines) != 1 {
+		return nil, fmt.Errorf("invalid public key: expected one key line, found %d", len(lines))
+	}
+	buf, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, fmt.Errorf("decoding public key: %v", err)
+	}
+	if len(buf) != encodedPublicKeySize {
+		return nil, fmt.Errorf("invalid public key size %d", len(buf))
+	}
+	if string(buf[:2]) != algorithmLegacy {
+		return nil, fmt.Errorf("unsupported public key algorithm %q", buf[:2])
+	}
+	pk := &PublicKey{
+		key: ed25519.PublicKey(buf[2+keyIDSize:]),
+	}
+	copy(pk.keyID[:], buf[2:2+keyIDSize])
+	return pk, nil
+}
+
+// ParseSignature parses the contents of a minisign signature file.
+func ParseSignature(contents string) (*Signature, error) {
+	if len(contents) > maxSignatureFileSize {
+		return nil, fmt.Errorf("signature too large")
+	}
+	lines := dataLines(contents)
+	if len(lines) != 3 {
+		return nil, fmt.Errorf("invalid signature: expected 3 lines, found %d", len(lines))
+	}
+	buf, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %v", err)
+	}
+	if len(buf) != encodedSignatureSize {
+		return nil, fmt.Errorf("invalid signature size %d", len(buf))
+	}
+	algorithm := string(buf[:2])
+	if algorithm != algorithmLegacy && algorithm != algorithmPrehashed {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+	if !strings.HasPrefix(lines[1], trustedCommentPrefix) {
+		return nil, fmt.Errorf("invalid signature: missing trusted comment")
+	}
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding global signature: %v", err)
+	}
+	if len(globalSignature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid global signature size %d", len(globalSignature))
+	}
+	sig := &Signature{
+		algorithm:       algorithm,
+		signature:       buf[2+keyIDSize:],
+		trustedComment:  strings.TrimPrefix(lines[1], trustedCommentPrefix),
+		globalSignature: globalSignature,
+	}
+	copy(sig.keyID[:], buf[2:2+keyIDSize])
+	return sig, nil
+}
+
+// TrustedComment returns the trusted comment of the signature. It is only
+// meaningful after the signature has been verified.
+func (s *Signature) TrustedComment() string {
+	return s.trustedComment
+}
+
+// Verify checks that sig is a valid signature of the data read from r made
+// with the private key belonging to pk.
+func (pk *PublicKey) Verify(r io.Reader, sig *Signature) error {
+	if !bytes.Equal(pk.keyID[:], sig.keyID[:]) {
+		return fmt.Errorf("signature key ID %X does not match public key ID %X",
+			sig.keyID, pk.keyID)
+	}
+	var message []byte
+	if sig.algorithm == algorithmPrehashed {
+		hash, err := blake2b.New512(nil)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(hash, r)
+		if err != nil {
+			return fmt.Errorf("reading data: %v", err)
+		}
+		message = hash.Sum(nil)
+	} else {
+		buf := new(bytes.Buffer)
+		_, err := io.Copy(buf, r)
+		if err != nil {
+			return fmt.Errorf("reading data: %v", err)
+		}
+		message = buf.Bytes()
+	}
+	if !ed25519.Verify(pk.key, message, sig.signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	global := append(append([]byte{}, sig.signature...), []byte(sig.trustedComment)...)
+	if !ed25519.Verify(pk.key, global, sig.globalSignature) {
+		return fmt.Errorf("invalid global signature")
+	}
+	return nil
+}
+
+// VerifyFile checks that sig is a valid signature of the file at path.
+func (pk *PublicKey) VerifyFile(path string, sig *Signature) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", path, err)
+	}
+	defer f.Close()
+	err = pk.Verify(f, sig)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
+)
+
+var testKeyID = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+func encodePublicKey(pub ed25519.PublicKey) string {
+	buf := append([]byte(algorithmLegacy), testKeyID...)
+	buf = append(buf, pub...)
+	return "untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(buf) + "\n"
+}
+
+func encodeSignature(priv ed25519.PrivateKey, algorithm string, message []byte, comment string) string {
+	if algorithm == algorithmPrehashed {
+		sum := blake2b.Sum512(message)
+		message = sum[:]
+	}
+	sig := ed25519.Sign(priv, message)
+	global := ed25519.Sign(priv, append(append([]byte{}, sig...), []byte(comment)...))
+	buf := append([]byte(algorithm), testKeyID...)
+	buf = append(buf, sig...)
+	return "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(buf) + "\n" +
+		trustedCommentPrefix + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	message := []byte("itzo binary")
+
+	pk, err := ParsePublicKey(encodePublicKey(pub))
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		signature string
+		message   []byte
+		failure   bool
+	}{
+		{
+			name:      "legacy signature",
+			signature: encodeSignature(priv, algorithmLegacy, message, "file:itzo"),
+			message:   message,
+		},
+		{
+			name:      "prehashed signature",
+			signature: encodeSignature(priv, algorithmPrehashed, message, "file:itzo"),
+			message:   message,
+		},
+		{
+			name:      "modified message",
+			signature: encodeSignature(priv, algorithmPrehashed, message, "file:itzo"),
+			message:   []byte("itzo binary!"),
+			failure:   true,
+		},
+		{
+			name:      "wrong key",
+			signature: encodeSignature(otherPriv, algorithmPrehashed, message, "file:itzo"),
+			message:   message,
+			failure:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig, err := ParseSignature(tc.signature)
+			assert.NoError(t, err)
+			err = pk.Verify(bytes.NewReader(tc.message), sig)
+			if tc.failure {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestVerifyModifiedTrustedComment(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	message := []byte("itzo binary")
+	pk, err := ParsePublicKey(encodePublicKey(pub))
+	assert.NoError(t, err)
+	sig, err := ParseSignature(encodeSignature(priv, algorithmLegacy, message, "file:itzo"))
+	assert.NoError(t, err)
+	sig.trustedComment = "file:evil"
+	assert.Error(t, pk.Verify(bytes.NewReader(message), sig))
+}
+
+func TestParseSignatureInvalid(t *testing.T) {
+	_, err := ParseSignature("untrusted comment: foo\nbm90IGEgc2lnbmF0dXJl\n")
+	assert.Error(t, err)
+}
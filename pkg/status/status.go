@@ -0,0 +1,133 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+type Result string
+
+const (
+	ResultRunning   Result = "running"
+	ResultSucceeded Result = "succeeded"
+	ResultFailed    Result = "failed"
+)
+
+// Phase is one step of starting up, e.g. running an addon or downloading
+// itzo.
+type Phase struct {
+	Name     string     `json:"name"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end,omitempty"`
+	Duration float64    `json:"durationSeconds"`
+	Result   Result     `json:"result"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Status keeps track of the phases the launcher went through, and writes them
+// to a JSON file each time a phase starts or ends. It is safe for concurrent
+// use.
+type Status struct {
+	path    string
+	mu      sync.Mutex
+	Version string    `json:"version"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+	Phases  []*Phase  `json:"phases"`
+	now     func() time.Time
+}
+
+func New(path, version string) *Status {
+	s := &Status{
+		path:    path,
+		Version: version,
+		Phases:  make([]*Phase, 0),
+		now:     time.Now,
+	}
+	s.Started = s.now()
+	return s
+}
+
+// Start records the start of a phase.
+func (s *Status) Start(name string) *Phase {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := &Phase{
+		Name:   name,
+		Start:  s.now(),
+		Result: ResultRunning,
+	}
+	s.Phases = append(s.Phases, p)
+	s.write()
+	return p
+}
+
+// End records the end of a phase; err is the error the phase failed with, if
+// any.
+func (s *Status) End(p *Phase, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := s.now()
+	p.End = &end
+	p.Duration = end.Sub(p.Start).Seconds()
+	p.Result = ResultSucceeded
+	if err != nil {
+		p.Result = ResultFailed
+		p.Error = err.Error()
+	}
+	s.write()
+}
+
+// write saves the status file. Failing to write it is not fatal for the
+// launcher, so errors are only logged.
+func (s *Status) write() {
+	if s.path == "" {
+		return
+	}
+	s.Updated = s.now()
+	err := writeFile(s.path, s)
+	if err != nil {
+		klog.Warningf("writing status file: %v", err)
+	}
+}
+
+func writeFile(path string, v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding status: %v", err)
+	}
+	dir := filepath.Dir(path)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("creating %s: %v", dir, err)
+	}
+	// Write to a temporary file first, so readers never see a partially
+	// written status file.
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary file in %s: %v", dir, err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(append(buf, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %v", f.Name(), err)
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return fmt.Errorf("renaming %s to %s: %v", f.Name(), path, err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run", "status.json")
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	s := New(path, "v1.0.0")
+	s.now = func() time.Time {
+		return now
+	}
+
+	params := s.Start("instance-parameters")
+	now = now.Add(2 * time.Second)
+	s.End(params, errors.New("no parameters found"))
+	addons := s.Start("addons")
+
+	read := func() *Status {
+		contents, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		st := &Status{}
+		assert.NoError(t, json.Unmarshal(contents, st))
+		return st
+	}
+	st := read()
+	assert.Equal(t, "v1.0.0", st.Version)
+	assert.Len(t, st.Phases, 2)
+	assert.Equal(t, ResultFailed, st.Phases[0].Result)
+	assert.Equal(t, "no parameters found", st.Phases[0].Error)
+	assert.Equal(t, 2.0, st.Phases[0].Duration)
+	assert.Equal(t, ResultRunning, st.Phases[1].Result)
+	assert.Nil(t, st.Phases[1].End)
+
+	now = now.Add(time.Second)
+	s.End(addons, nil)
+	st = read()
+	assert.Equal(t, ResultSucceeded, st.Phases[1].Result)
+	assert.Equal(t, now, *st.Phases[1].End)
+	assert.Equal(t, now, st.Updated)
+
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
@@ -0,0 +1,391 @@
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s.io/klog"
+)
+
+type RestartPolicy string
+
+// errRestarted is the error of a run ended by Restart, if the process exited
+// successfully, so it's still treated as a failure.
+var errRestarted = errors.New("terminated for restarting")
+
+const (
+	RestartAlways    RestartPolicy = "always"
+	RestartOnFailure RestartPolicy = "on-failure"
+	RestartNever     RestartPolicy = "never"
+)
+
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	switch p := RestartPolicy(policy); p {
+	case RestartAlways, RestartOnFailure, RestartNever:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid restart policy %q", policy)
+}
+
+// Supervisor runs a process, and restarts it according to its restart policy
+// when it exits. Restarts are delayed with exponential backoff, and if the
+// process keeps failing, the supervisor gives up.
+type Supervisor struct {
+	Policy RestartPolicy
+	// BackoffBase is the delay before the first restart, doubled after every
+	// consecutive restart up to BackoffMax.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// MinUptime is how long the process needs to run to be considered
+	// started successfully. It resets the backoff.
+	MinUptime time.Duration
+	// The supervisor gives up if the process fails MaxFailures times within
+	// FailureWindow.
+	MaxFailures   int
+	FailureWindow time.Duration
+	// Command returns the command to run. It is called before each start.
+	Command func() (*exec.Cmd, error)
+	// Up is called when the process has been running for MinUptime.
+	Up func(cmd *exec.Cmd)
+	// Exited is called when the process exits. If it returns true, the
+	// process is restarted right away, regardless of the restart policy.
+	Exited func(cmd *exec.Cmd, uptime time.Duration, err error) bool
+	// GracePeriod is how long the process has to exit after Stop, before it
+	// gets killed.
+	GracePeriod time.Duration
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	running  bool
+	stopping bool
+	stopCh   chan struct{}
+	// reloading is set while the process is terminated by Reload.
+	reloading bool
+	// restarting is set while the process is terminated by Restart.
+	restarting bool
+}
+
+func (s *Supervisor) stopChan() chan struct{} {
+	if s.stopCh == nil {
+		s.stopCh = make(chan struct{})
+	}
+	return s.stopCh
+}
+
+func (s *Supervisor) isStopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopping
+}
+
+// signalGroup sends sig to the process group of cmd.
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) {
+	err := syscall.Kill(-cmd.Process.Pid, sig)
+	if err != nil {
+		klog.Warningf("sending %v to %v: %v", sig, cmd, err)
+	}
+}
+
+// Stop forwards sig to the process group of the running process, and makes
+// Run return once it has exited. If the process does not exit within
+// GracePeriod, it is killed. It returns false if Run has not been called yet;
+// Run will return right away in that case.
+func (s *Supervisor) Stop(sig os.Signal) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		return s.running
+	}
+	s.stopping = true
+	close(s.stopChan())
+	if s.cmd == nil {
+		return s.running
+	}
+	sysSig, ok := sig.(syscall.Signal)
+	if !ok {
+		sysSig = syscall.SIGTERM
+	}
+	s.terminate(sysSig)
+	return true
+}
+
+// Kill stops the supervisor like Stop, but kills the process group of the
+// running process right away, without waiting for GracePeriod.
+func (s *Supervisor) Kill() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopping {
+		s.stopping = true
+		close(s.stopChan())
+	}
+	if s.cmd == nil {
+		return
+	}
+	klog.Warningf("killing %v", s.cmd)
+	signalGroup(s.cmd, syscall.SIGKILL)
+}
+
+// Restart terminates the running process like Stop, e.g. because it's
+// unhealthy, and it's started again like after a failure: with backoff and
+// counting towards MaxFailures, but regardless of the restart policy, even if
+// it exits successfully. It's a no-op if no process is running.
+func (s *Supervisor) Restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping || s.cmd == nil {
+		return
+	}
+	s.restarting = true
+	s.terminate(syscall.SIGTERM)
+}
+
+// Reload terminates the running process like Restart, e.g. after its config
+// has changed, but it's started again right away, regardless of the restart
+// policy and without counting it as a failure. It returns false if no process
+// is running.
+func (s *Supervisor) Reload() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping || s.cmd == nil {
+		return false
+	}
+	s.reloading = true
+	s.terminate(syscall.SIGTERM)
+	return true
+}
+
+// reloaded checks whether the last run was ended by Reload, and clears it.
+func (s *Supervisor) reloaded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reloading := s.reloading
+	s.reloading = false
+	return reloading
+}
+
+// restarted checks whether the last run was ended by Restart, and clears it.
+func (s *Supervisor) restarted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	restarting := s.restarting
+	s.restarting = false
+	return restarting
+}
+
+// terminate sends sig to the running process, and kills it if it's still
+// running after GracePeriod. The caller must hold s.mu.
+func (s *Supervisor) terminate(sig syscall.Signal) {
+	cmd := s.cmd
+	exited := s.exited
+	klog.Infof("sending %v to %v", sig, cmd)
+	signalGroup(cmd, sig)
+	go func() {
+		select {
+		case <-exited:
+		case <-time.After(s.GracePeriod):
+			klog.Warningf("%v did not exit within %v, killing it", cmd, s.GracePeriod)
+			signalGroup(cmd, syscall.SIGKILL)
+		}
+	}()
+}
+
+// ExitCode returns the exit status of a process that ended with err.
+// Processes killed by a signal get 128 + the signal number, like in shells.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+// pipeOutput replaces the stdout and stderr writers of cmd that are not files
+// with pipes, and copies from them in goroutines that cmd.Wait does not wait
+// for. Otherwise Wait would block until every child that inherited the output
+// of the process has exited too. It returns the write ends of the pipes,
+// which have to be closed once cmd is started.
+func pipeOutput(cmd *exec.Cmd) ([]*os.File, error) {
+	files := make([]*os.File, 0, 2)
+	for _, out := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+		w := *out
+		if w == nil {
+			continue
+		}
+		if _, ok := w.(*os.File); ok {
+			continue
+		}
+		r, pw, err := os.Pipe()
+		if err != nil {
+			closeFiles(files)
+			return nil, fmt.Errorf("creating pipe for output of %v: %v", cmd, err)
+		}
+		go func() {
+			_, _ = io.Copy(w, r)
+			r.Close()
+		}()
+		*out = pw
+		files = append(files, pw)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// run starts the command and waits for it to exit.
+func (s *Supervisor) run() (*exec.Cmd, time.Duration, error) {
+	if s.isStopping() {
+		return nil, 0, nil
+	}
+	cmd, err := s.Command()
+	if err != nil {
+		return nil, 0, err
+	}
+	// Run the process in its own process group, so signals can be forwarded
+	// to all of its children.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	pipes, err := pipeOutput(cmd)
+	if err != nil {
+		return cmd, 0, err
+	}
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		closeFiles(pipes)
+		return nil, 0, nil
+	}
+	klog.Infof("running %v", cmd)
+	start := time.Now()
+	err = cmd.Start()
+	// The process has its own copies of the write ends now.
+	closeFiles(pipes)
+	if err != nil {
+		s.mu.Unlock()
+		return cmd, 0, fmt.Errorf("starting %v: %v", cmd, err)
+	}
+	exited := make(chan struct{})
+	s.cmd = cmd
+	s.exited = exited
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.cmd = nil
+		s.mu.Unlock()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		close(exited)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(s.MinUptime):
+		if s.Up != nil {
+			s.Up(cmd)
+		}
+		err = <-done
+	}
+	return cmd, time.Since(start), err
+}
+
+// Run runs the process until the restart policy says it should not be
+// restarted anymore, it's crash looping, or Stop is called. The error from
+// the last run is returned.
+func (s *Supervisor) Run() error {
+	s.mu.Lock()
+	stopCh := s.stopChan()
+	s.running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+	failures := make([]time.Time, 0)
+	attempt := 0
+	for {
+		cmd, uptime, err := s.run()
+		if cmd == nil && err == nil {
+			// Stopped before starting.
+			return nil
+		}
+		if s.isStopping() {
+			klog.Infof("%v stopped after %v: %v", cmd, uptime, err)
+			return err
+		}
+		if s.reloaded() {
+			klog.Infof("%v stopped after %v for reloading, starting it again", cmd, uptime)
+			attempt = 0
+			continue
+		}
+		restarted := s.restarted()
+		if restarted {
+			if err == nil {
+				err = errRestarted
+			}
+			klog.Warningf("%v restarted after %v: %v", cmd, uptime, err)
+		} else if err != nil {
+			klog.Errorf("%v exited after %v: %v", cmd, uptime, err)
+		} else {
+			klog.Warningf("%v exited after %v", cmd, uptime)
+		}
+		if s.Exited != nil && cmd != nil && s.Exited(cmd, uptime, err) {
+			continue
+		}
+		switch {
+		case restarted:
+			// Started again regardless of the policy.
+		case s.Policy == RestartNever:
+			return err
+		case s.Policy == RestartOnFailure && err == nil:
+			return nil
+		}
+		if err != nil {
+			now := time.Now()
+			failures = append(failures, now)
+			for len(failures) > 0 && now.Sub(failures[0]) > s.FailureWindow {
+				failures = failures[1:]
+			}
+			if s.MaxFailures > 0 && len(failures) >= s.MaxFailures {
+				return fmt.Errorf("giving up after %d failures in %v: %w",
+					len(failures), s.FailureWindow, err)
+			}
+		}
+		if uptime >= s.MinUptime {
+			attempt = 0
+		}
+		delay := s.backoff(attempt)
+		attempt++
+		klog.Infof("restarting in %v", delay)
+		select {
+		case <-time.After(delay):
+		case <-stopCh:
+			return err
+		}
+	}
+}
+
+func (s *Supervisor) backoff(attempt int) time.Duration {
+	d := s.BackoffBase << uint(attempt)
+	if d <= 0 || d > s.BackoffMax {
+		d = s.BackoffMax
+	}
+	return d
+}
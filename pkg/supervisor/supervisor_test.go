@@ -0,0 +1,259 @@
+package supervisor
+
+import (
+	"bytes"
+	"os/exec"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSupervisor(policy RestartPolicy, script func(run int) string) (*Supervisor, *int) {
+	runs := 0
+	s := &Supervisor{
+		Policy:        policy,
+		BackoffBase:   time.Millisecond,
+		BackoffMax:    10 * time.Millisecond,
+		MinUptime:     time.Second,
+		MaxFailures:   3,
+		FailureWindow: time.Minute,
+		Command: func() (*exec.Cmd, error) {
+			runs++
+			return exec.Command("/bin/sh", "-c", script(runs)), nil
+		},
+	}
+	return s, &runs
+}
+
+func TestSupervisorOnFailure(t *testing.T) {
+	s, runs := newTestSupervisor(RestartOnFailure, func(run int) string {
+		if run < 3 {
+			return "exit 1"
+		}
+		return "exit 0"
+	})
+	err := s.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, *runs)
+}
+
+func TestSupervisorNever(t *testing.T) {
+	s, runs := newTestSupervisor(RestartNever, func(run int) string {
+		return "exit 1"
+	})
+	err := s.Run()
+	assert.Error(t, err)
+	assert.Equal(t, 1, *runs)
+}
+
+func TestSupervisorCrashLoop(t *testing.T) {
+	s, runs := newTestSupervisor(RestartAlways, func(run int) string {
+		if run%2 == 0 {
+			return "exit 0"
+		}
+		return "exit 1"
+	})
+	err := s.Run()
+	assert.Error(t, err)
+	// Clean exits don't count as failures.
+	assert.Equal(t, 5, *runs)
+}
+
+func TestSupervisorExitedHook(t *testing.T) {
+	s, runs := newTestSupervisor(RestartNever, func(run int) string {
+		if run == 1 {
+			return "exit 1"
+		}
+		return "exit 0"
+	})
+	s.Exited = func(cmd *exec.Cmd, uptime time.Duration, err error) bool {
+		return err != nil
+	}
+	err := s.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *runs)
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+	policy, err := ParseRestartPolicy("on-failure")
+	assert.NoError(t, err)
+	assert.Equal(t, RestartOnFailure, policy)
+	_, err = ParseRestartPolicy("sometimes")
+	assert.Error(t, err)
+}
+
+func TestSupervisorStop(t *testing.T) {
+	s, runs := newTestSupervisor(RestartAlways, func(run int) string {
+		return "sleep 10"
+	})
+	s.GracePeriod = 5 * time.Second
+	up := make(chan struct{})
+	s.Up = func(cmd *exec.Cmd) {
+		close(up)
+	}
+	s.MinUptime = 10 * time.Millisecond
+	go func() {
+		<-up
+		assert.True(t, s.Stop(syscall.SIGTERM))
+	}()
+	err := s.Run()
+	assert.Equal(t, 128+int(syscall.SIGTERM), ExitCode(err))
+	assert.Equal(t, 1, *runs)
+}
+
+func TestSupervisorStopBeforeRun(t *testing.T) {
+	s, runs := newTestSupervisor(RestartAlways, func(run int) string {
+		return "exit 0"
+	})
+	assert.False(t, s.Stop(syscall.SIGTERM))
+	err := s.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, *runs)
+}
+
+func TestSupervisorStopKill(t *testing.T) {
+	s, _ := newTestSupervisor(RestartAlways, func(run int) string {
+		return "trap '' TERM; sleep 10"
+	})
+	s.GracePeriod = 100 * time.Millisecond
+	s.MinUptime = 10 * time.Millisecond
+	up := make(chan struct{})
+	s.Up = func(cmd *exec.Cmd) {
+		close(up)
+	}
+	go func() {
+		<-up
+		s.Stop(syscall.SIGTERM)
+	}()
+	start := time.Now()
+	err := s.Run()
+	assert.Equal(t, 128+int(syscall.SIGKILL), ExitCode(err))
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
+
+func TestSupervisorKill(t *testing.T) {
+	s, runs := newTestSupervisor(RestartAlways, func(run int) string {
+		return "trap '' TERM; sleep 10"
+	})
+	s.GracePeriod = 10 * time.Second
+	s.MinUptime = 10 * time.Millisecond
+	up := make(chan struct{})
+	s.Up = func(cmd *exec.Cmd) {
+		close(up)
+	}
+	go func() {
+		<-up
+		assert.True(t, s.Stop(syscall.SIGTERM))
+		time.Sleep(50 * time.Millisecond)
+		s.Kill()
+	}()
+	start := time.Now()
+	err := s.Run()
+	assert.Equal(t, 128+int(syscall.SIGKILL), ExitCode(err))
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	assert.Equal(t, 1, *runs)
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	s, runs := newTestSupervisor(RestartOnFailure, func(run int) string {
+		if run == 1 {
+			return "sleep 10"
+		}
+		return "exit 0"
+	})
+	s.GracePeriod = 5 * time.Second
+	s.MinUptime = 10 * time.Millisecond
+	s.Up = func(cmd *exec.Cmd) {
+		s.Restart()
+	}
+	err := s.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *runs)
+}
+
+func TestSupervisorRestartCleanExit(t *testing.T) {
+	for _, policy := range []RestartPolicy{RestartOnFailure, RestartNever} {
+		s, runs := newTestSupervisor(policy, func(run int) string {
+			if run == 1 {
+				// Exits successfully on SIGTERM.
+				return "trap 'exit 0' TERM; sleep 10 & wait"
+			}
+			return "exit 0"
+		})
+		s.GracePeriod = 5 * time.Second
+		s.MinUptime = 10 * time.Millisecond
+		s.Up = func(cmd *exec.Cmd) {
+			s.Restart()
+		}
+		err := s.Run()
+		assert.NoError(t, err, policy)
+		assert.Equal(t, 2, *runs, policy)
+	}
+}
+
+func TestSupervisorReload(t *testing.T) {
+	s, runs := newTestSupervisor(RestartNever, func(run int) string {
+		if run == 1 {
+			return "sleep 10"
+		}
+		return "exit 0"
+	})
+	s.GracePeriod = 5 * time.Second
+	s.MinUptime = 10 * time.Millisecond
+	exited := 0
+	s.Exited = func(cmd *exec.Cmd, uptime time.Duration, err error) bool {
+		exited++
+		return false
+	}
+	s.Up = func(cmd *exec.Cmd) {
+		assert.True(t, s.Reload())
+	}
+	err := s.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *runs)
+	// Only the second run exited on its own.
+	assert.Equal(t, 1, exited)
+	assert.False(t, s.Reload())
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSupervisorOutputInheritedByChild(t *testing.T) {
+	out := &syncBuffer{}
+	s := &Supervisor{
+		Policy: RestartNever,
+		Command: func() (*exec.Cmd, error) {
+			// The background sleep keeps the output open after the shell
+			// exited.
+			cmd := exec.Command("/bin/sh", "-c", "sleep 3 & echo hello")
+			cmd.Stdout = out
+			cmd.Stderr = out
+			return cmd, nil
+		},
+	}
+	start := time.Now()
+	err := s.Run()
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < 2*time.Second, "Run waited for the child")
+	assert.Eventually(t, func() bool {
+		return out.String() == "hello\n"
+	}, 2*time.Second, 10*time.Millisecond)
+}
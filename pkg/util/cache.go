@@ -0,0 +1,212 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+	"k8s.io/klog"
+)
+
+var (
+	// ProgCacheMaxEntries is the number of cached binaries kept per program.
+	ProgCacheMaxEntries = 5
+)
+
+// ProgCache keeps downloaded versions of a program around, so they can be
+// used when a download fails.
+type ProgCache struct {
+	dir  string
+	prog string
+}
+
+func NewProgCache(dir, prog string) *ProgCache {
+	return &ProgCache{
+		dir:  dir,
+		prog: prog,
+	}
+}
+
+// Path returns the path of the cached binary for version.
+func (c *ProgCache) Path(version string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%s-%s", c.prog, version))
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening %s: %+v", src, err)
+	}
+	defer in.Close()
+	tmpPath := dst + ".part"
+	out, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("opening %s for writing: %+v", tmpPath, err)
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("copying %s to %s: %+v", src, tmpPath, err)
+	}
+	err = out.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("closing %s: %+v", tmpPath, err)
+	}
+	err = os.Rename(tmpPath, dst)
+	if err != nil {
+		return fmt.Errorf("renaming %s to %s: %+v", tmpPath, dst, err)
+	}
+	return nil
+}
+
+// versionPath returns where the version reported by the cached binary at
+// cachePath is recorded.
+func versionPath(cachePath string) string {
+	return cachePath + ".version"
+}
+
+// Store saves a copy of the binary at path as version in the cache, and
+// removes the oldest cached binaries if there are more than
+// ProgCacheMaxEntries. The version the binary reports when run with
+// versionArg is recorded too, for ordering the cached binaries.
+func (c *ProgCache) Store(path, version, versionArg string) error {
+	err := os.MkdirAll(c.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("ensuring %s exists: %v", c.dir, err)
+	}
+	cachePath := c.Path(version)
+	err = copyFile(path, cachePath, 0755)
+	if err != nil {
+		return err
+	}
+	reported, err := ProgVersion(cachePath, versionArg)
+	if err != nil {
+		klog.Warningf("getting version of %s: %v", cachePath, err)
+		os.Remove(versionPath(cachePath))
+	} else {
+		err = ioutil.WriteFile(versionPath(cachePath), []byte(reported), 0644)
+		if err != nil {
+			klog.Warningf("recording version of %s: %v", cachePath, err)
+		}
+	}
+	klog.V(2).Infof("cached %s as %s", path, cachePath)
+	c.prune()
+	return nil
+}
+
+// cacheEntry is a cached binary.
+type cacheEntry struct {
+	path    string
+	modTime time.Time
+	// version is the canonical semver version recorded by Store, or empty
+	// if it's unknown.
+	version string
+}
+
+// entries returns the cached binaries, the most recently stored first.
+func (c *ProgCache) entries() []cacheEntry {
+	fis, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("listing cache %s: %v", c.dir, err)
+		}
+		return nil
+	}
+	entries := make([]cacheEntry, 0, len(fis))
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() ||
+			!strings.HasPrefix(name, c.prog+"-") ||
+			strings.HasSuffix(name, ".part") ||
+			strings.HasSuffix(name, ".version") {
+			continue
+		}
+		entry := cacheEntry{
+			path:    filepath.Join(c.dir, name),
+			modTime: fi.ModTime(),
+		}
+		contents, err := ioutil.ReadFile(versionPath(entry.path))
+		if err == nil {
+			entry.version, _ = canonicalVersion(strings.TrimSpace(string(contents)))
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].modTime.After(entries[j].modTime)
+	})
+	return entries
+}
+
+// byVersion sorts entries by their recorded versions, the highest first.
+// Entries with the same version are ordered by when they were stored, and
+// the ones with unknown versions come last.
+func byVersion(entries []cacheEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		vi, vj := entries[i].version, entries[j].version
+		if vi == "" || vj == "" {
+			return vi != "" && vj == ""
+		}
+		return semver.Compare(vi, vj) > 0
+	})
+}
+
+func (c *ProgCache) remove(path string) {
+	klog.V(2).Infof("removing %s from cache", path)
+	err := os.Remove(path)
+	if err != nil {
+		klog.Warningf("removing %s from cache: %v", path, err)
+	}
+	os.Remove(versionPath(path))
+}
+
+func (c *ProgCache) prune() {
+	entries := c.entries()
+	for i := ProgCacheMaxEntries; i < len(entries); i++ {
+		c.remove(entries[i].path)
+	}
+}
+
+// Find returns the cached binary with the highest version that satisfies the
+// version constraint. If constraint is empty, the cached binary with the
+// highest version is returned. An empty string is returned if there is no
+// suitable binary in the cache.
+func (c *ProgCache) Find(constraint, versionArg string) string {
+	entries := c.entries()
+	byVersion(entries)
+	for _, entry := range entries {
+		if constraint == "" || VersionMatch(entry.path, constraint, versionArg) {
+			return entry.path
+		}
+	}
+	return ""
+}
+
+// RemoveDigest deletes all cached binaries with the SHA-256 digest.
+func (c *ProgCache) RemoveDigest(digest string) {
+	for _, entry := range c.entries() {
+		d, err := FileDigest(entry.path)
+		if err != nil || d != digest {
+			continue
+		}
+		c.remove(entry.path)
+	}
+}
+
+// Restore copies the cached binary at cachePath to path.
+func (c *ProgCache) Restore(cachePath, path string) error {
+	_ = os.MkdirAll(filepath.Dir(path), 0755)
+	err := copyFile(cachePath, path, 0755)
+	if err != nil {
+		return err
+	}
+	klog.V(2).Infof("restored %s from %s", path, cachePath)
+	return nil
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFakeProg(t *testing.T, path, version string, mtime time.Time) {
+	script := fmt.Sprintf("#!/bin/sh\necho prog version %s\n", version)
+	err := ioutil.WriteFile(path, []byte(script), 0755)
+	assert.NoError(t, err)
+	err = os.Chtimes(path, mtime, mtime)
+	assert.NoError(t, err)
+}
+
+func TestProgCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	cache := NewProgCache(filepath.Join(dir, "cache"), "prog")
+
+	assert.Equal(t, "", cache.Find("", "--version"))
+
+	now := time.Now()
+	writeFakeProg(t, src, "1.2.0", now)
+	assert.NoError(t, cache.Store(src, "1.2.0", "--version"))
+	os.Chtimes(cache.Path("1.2.0"), now.Add(-time.Hour), now.Add(-time.Hour))
+	writeFakeProg(t, src, "1.1.0", now)
+	assert.NoError(t, cache.Store(src, "1.1.0", "--version"))
+
+	// The highest version wins, even though it was stored first.
+	assert.Equal(t, cache.Path("1.2.0"), cache.Find("", "--version"))
+	assert.Equal(t, cache.Path("1.2.0"), cache.Find("1.0.0", "--version"))
+	assert.Equal(t, cache.Path("1.1.0"), cache.Find("<1.2.0", "--version"))
+	assert.Equal(t, cache.Path("1.2.0"), cache.Find("1.2.0", "--version"))
+	assert.Equal(t, "", cache.Find("1.3.0", "--version"))
+
+	// Touching an older binary doesn't make it preferred.
+	os.Chtimes(cache.Path("1.1.0"), now.Add(time.Hour), now.Add(time.Hour))
+	assert.Equal(t, cache.Path("1.2.0"), cache.Find("", "--version"))
+
+	// The same version under another name is picked if it was stored later.
+	writeFakeProg(t, src, "1.2.0", now)
+	assert.NoError(t, cache.Store(src, "latest", "--version"))
+	os.Chtimes(cache.Path("latest"), now.Add(time.Minute), now.Add(time.Minute))
+	assert.Equal(t, cache.Path("latest"), cache.Find("", "--version"))
+
+	dst := filepath.Join(dir, "bin", "prog")
+	assert.NoError(t, cache.Restore(cache.Path("1.2.0"), dst))
+	assert.True(t, VersionMatch(dst, "1.2.0", "--version"))
+}
+
+func TestProgCachePrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	cache := NewProgCache(filepath.Join(dir, "cache"), "prog")
+
+	now := time.Now()
+	writeFakeProg(t, src, "1.0.0", now)
+	for i := 0; i < ProgCacheMaxEntries+2; i++ {
+		version := fmt.Sprintf("1.0.%d", i)
+		assert.NoError(t, cache.Store(src, version, "--version"))
+		mtime := now.Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(cache.Path(version), mtime, mtime)
+	}
+	assert.Len(t, cache.entries(), ProgCacheMaxEntries)
+	_, err = os.Stat(cache.Path("1.0.0"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(cache.Path("1.0.1"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(versionPath(cache.Path("1.0.1")))
+	assert.True(t, os.IsNotExist(err))
+}
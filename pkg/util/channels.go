@@ -0,0 +1,120 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+const maxChannelManifestSize = 1024 * 1024
+
+// ChannelManifest maps release channels like "stable" or "latest" to concrete
+// releases. Example:
+//
+//	{
+//	  "channels": {
+//	    "stable": {
+//	      "version": "1.4.1",
+//	      "architectures": {
+//	        "amd64": {"url": "itzo-1.4.1", "sha256": "..."},
+//	        "arm64": {"url": "itzo-arm64-1.4.1", "sha256": "..."}
+//	      }
+//	    }
+//	  }
+//	}
+type ChannelManifest struct {
+	Channels map[string]Channel `json:"channels"`
+}
+
+type Channel struct {
+	Version       string                     `json:"version"`
+	Architectures map[string]ChannelArtifact `json:"architectures"`
+}
+
+type ChannelArtifact struct {
+	// URL is either absolute, or relative to the location of the manifest.
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Release is a concrete artifact a channel resolved to.
+type Release struct {
+	Channel string
+	Version string
+	URL     string
+	SHA256  string
+}
+
+// FetchChannelManifest downloads and parses the channel manifest at url. If
+// there is no manifest, nil is returned without an error.
+func FetchChannelManifest(url string) (*ChannelManifest, error) {
+	contents, err := FetchSmallFile(url, maxChannelManifestSize)
+	if err != nil || contents == nil {
+		return nil, err
+	}
+	manifest := &ChannelManifest{}
+	err = json.Unmarshal(contents, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("parsing channel manifest %s: %v", url, err)
+	}
+	return manifest, nil
+}
+
+// ResolveConstraint looks up the release with the highest version satisfying
+// constraint among all channels for arch. It returns nil if there is none.
+func (m *ChannelManifest) ResolveConstraint(constraint *Constraint, arch, baseURL string) (*Release, error) {
+	channels := make([]string, 0, len(m.Channels))
+	for name, ch := range m.Channels {
+		if _, found := ch.Architectures[arch]; found && constraint.Check(ch.Version) {
+			channels = append(channels, name)
+		}
+	}
+	if len(channels) == 0 {
+		return nil, nil
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		vi, _ := canonicalVersion(m.Channels[channels[i]].Version)
+		vj, _ := canonicalVersion(m.Channels[channels[j]].Version)
+		if c := semver.Compare(vi, vj); c != 0 {
+			return c > 0
+		}
+		return channels[i] < channels[j]
+	})
+	return m.Resolve(channels[0], arch, baseURL)
+}
+
+// Resolve looks up the release of channel for arch. URLs relative to the
+// manifest are resolved using baseURL. It returns nil if the channel or the
+// architecture is not in the manifest.
+func (m *ChannelManifest) Resolve(channel, arch, baseURL string) (*Release, error) {
+	ch, found := m.Channels[channel]
+	if !found {
+		return nil, nil
+	}
+	artifact, found := ch.Architectures[arch]
+	if !found {
+		return nil, nil
+	}
+	if ch.Version == "" || artifact.URL == "" {
+		return nil, fmt.Errorf("incomplete release for channel %q on %s", channel, arch)
+	}
+	release := &Release{
+		Channel: channel,
+		Version: ch.Version,
+		URL:     artifact.URL,
+	}
+	if !strings.Contains(release.URL, "://") {
+		release.URL = strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(release.URL, "/")
+	}
+	if artifact.SHA256 != "" {
+		digest, err := ParseDigest(artifact.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("channel %q on %s: %v", channel, arch, err)
+		}
+		release.SHA256 = digest
+	}
+	return release, nil
+}
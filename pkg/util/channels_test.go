@@ -0,0 +1,120 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testChannelManifest = `{
+  "channels": {
+    "stable": {
+      "version": "1.4.1",
+      "architectures": {
+        "amd64": {
+          "url": "itzo-1.4.1",
+          "sha256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+        },
+        "arm64": {"url": "https://example.com/itzo-arm64-1.4.1"}
+      }
+    },
+    "broken": {
+      "architectures": {
+        "amd64": {"url": "itzo-broken"}
+      }
+    }
+  }
+}`
+
+func TestChannelManifestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "channels.json")
+	err = ioutil.WriteFile(path, []byte(testChannelManifest), 0644)
+	assert.NoError(t, err)
+
+	manifest, err := FetchChannelManifest("file://" + path)
+	assert.NoError(t, err)
+	assert.NotNil(t, manifest)
+
+	release, err := manifest.Resolve("stable", "amd64", "s3://bucket/itzo/")
+	assert.NoError(t, err)
+	assert.Equal(t, &Release{
+		Channel: "stable",
+		Version: "1.4.1",
+		URL:     "s3://bucket/itzo/itzo-1.4.1",
+		SHA256:  "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}, release)
+
+	release, err = manifest.Resolve("stable", "arm64", "s3://bucket/itzo")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/itzo-arm64-1.4.1", release.URL)
+
+	release, err = manifest.Resolve("beta", "amd64", "s3://bucket/itzo")
+	assert.NoError(t, err)
+	assert.Nil(t, release)
+
+	_, err = manifest.Resolve("broken", "amd64", "s3://bucket/itzo")
+	assert.Error(t, err)
+
+	manifest, err = FetchChannelManifest("file://" + filepath.Join(dir, "missing.json"))
+	assert.NoError(t, err)
+	assert.Nil(t, manifest)
+}
+
+func TestChannelManifestResolveConstraint(t *testing.T) {
+	manifest := &ChannelManifest{
+		Channels: map[string]Channel{
+			"stable": {
+				Version: "1.4.1",
+				Architectures: map[string]ChannelArtifact{
+					"amd64": {URL: "itzo-1.4.1"},
+				},
+			},
+			"beta": {
+				Version: "1.5.0",
+				Architectures: map[string]ChannelArtifact{
+					"amd64": {URL: "itzo-1.5.0"},
+				},
+			},
+			"old": {
+				Version: "1.3.0",
+				Architectures: map[string]ChannelArtifact{
+					"amd64": {URL: "itzo-1.3.0"},
+					"arm64": {URL: "itzo-arm64-1.3.0"},
+				},
+			},
+		},
+	}
+	testCases := []struct {
+		constraint string
+		arch       string
+		version    string
+	}{
+		{constraint: "<1.5", arch: "amd64", version: "1.4.1"},
+		{constraint: ">1.3", arch: "amd64", version: "1.5.0"},
+		{constraint: "~1.4", arch: "amd64", version: "1.4.1"},
+		{constraint: "^1.3", arch: "amd64", version: "1.5.0"},
+		{constraint: "!=1.5.0", arch: "amd64", version: "1.4.1"},
+		{constraint: ">1.3", arch: "arm64", version: ""},
+		{constraint: "~2.0", arch: "amd64", version: ""},
+	}
+	for _, tc := range testCases {
+		c, err := ParseConstraint(tc.constraint)
+		assert.NoError(t, err)
+		release, err := manifest.ResolveConstraint(c, tc.arch, "s3://bucket/itzo")
+		assert.NoError(t, err)
+		if tc.version == "" {
+			assert.Nil(t, release, tc.constraint)
+			continue
+		}
+		if assert.NotNil(t, release, tc.constraint) {
+			assert.Equal(t, tc.version, release.Version, tc.constraint)
+			assert.Equal(t, "s3://bucket/itzo/itzo-"+tc.version, release.URL)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// Constraint is a version constraint expression, e.g. "~1.4", ">=1.3.2 <1.5"
+// or "=1.4.1". Comparisons separated by whitespace or commas all have to
+// match; alternatives can be separated by "||". A bare version is a minimum
+// version, so "1.4.1" is the same as ">=1.4.1".
+type Constraint struct {
+	expr         string
+	alternatives [][]comparison
+}
+
+type comparison struct {
+	op string
+	// version is the canonical semver version, with a "v" prefix.
+	version string
+	// literal is the version as it was written in the expression.
+	literal string
+}
+
+var constraintOps = []string{">=", "<=", "!=", "==", ">", "<", "=", "~", "^"}
+
+func canonicalVersion(v string) (string, error) {
+	if v == "" {
+		return "", fmt.Errorf("empty version")
+	}
+	if v[0] != 'v' {
+		v = "v" + v
+	}
+	if !semver.IsValid(v) {
+		return "", fmt.Errorf("invalid version %q", v)
+	}
+	return v, nil
+}
+
+// versionParts returns the numeric major, minor and patch components of v,
+// and how many of them were specified.
+func versionParts(v string) ([3]int, int) {
+	parts := [3]int{}
+	core := strings.TrimPrefix(v, "v")
+	core = strings.SplitN(core, "-", 2)[0]
+	core = strings.SplitN(core, "+", 2)[0]
+	fields := strings.Split(core, ".")
+	for i := 0; i < len(fields) && i < len(parts); i++ {
+		parts[i], _ = strconv.Atoi(fields[i])
+	}
+	return parts, len(fields)
+}
+
+func formatVersion(parts [3]int) string {
+	return fmt.Sprintf("v%d.%d.%d", parts[0], parts[1], parts[2])
+}
+
+// expandRange turns tilde and caret ranges into a pair of comparisons.
+func expandRange(op, version, literal string) []comparison {
+	parts, n := versionParts(version)
+	upper := [3]int{}
+	switch {
+	case op == "~" && n > 1:
+		upper = [3]int{parts[0], parts[1] + 1, 0}
+	case op == "~":
+		upper = [3]int{parts[0] + 1, 0, 0}
+	case parts[0] > 0 || n == 1:
+		upper = [3]int{parts[0] + 1, 0, 0}
+	case parts[1] > 0 || n == 2:
+		upper = [3]int{0, parts[1] + 1, 0}
+	default:
+		upper = [3]int{0, 0, parts[2] + 1}
+	}
+	return []comparison{
+		{op: ">=", version: version, literal: literal},
+		{op: "<", version: formatVersion(upper)},
+	}
+}
+
+func parseComparison(term string) ([]comparison, error) {
+	op := ""
+	for _, o := range constraintOps {
+		if strings.HasPrefix(term, o) {
+			op = o
+			break
+		}
+	}
+	literal := strings.TrimSpace(term[len(op):])
+	version, err := canonicalVersion(literal)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "":
+		op = ">="
+	case "==":
+		op = "="
+	case "~", "^":
+		return expandRange(op, version, literal), nil
+	}
+	return []comparison{{op: op, version: version, literal: literal}}, nil
+}
+
+// ParseConstraint parses a version constraint expression.
+func ParseConstraint(expr string) (*Constraint, error) {
+	c := &Constraint{
+		expr: expr,
+	}
+	for _, alternative := range strings.Split(expr, "||") {
+		fields := strings.FieldsFunc(alternative, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		// Join operators separated from their version, e.g. ">= 1.2".
+		terms := make([]string, 0, len(fields))
+		for i := 0; i < len(fields); i++ {
+			term := fields[i]
+			if strings.Trim(term, "<>=!~^") == "" && i+1 < len(fields) {
+				i++
+				term += fields[i]
+			}
+			terms = append(terms, term)
+		}
+		if len(terms) == 0 {
+			return nil, fmt.Errorf("invalid version constraint %q", expr)
+		}
+		comparisons := make([]comparison, 0, len(terms))
+		for _, term := range terms {
+			cmps, err := parseComparison(term)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version constraint %q: %v", expr, err)
+			}
+			comparisons = append(comparisons, cmps...)
+		}
+		c.alternatives = append(c.alternatives, comparisons)
+	}
+	return c, nil
+}
+
+func (c *Constraint) String() string {
+	return c.expr
+}
+
+func (cmp comparison) check(version string) bool {
+	// Version reporting in itzo is broken: it reports its build hash as part
+	// of the prelease version.
+	if semver.Prerelease(cmp.version) == "" {
+		version = strings.SplitN(version, "-", 2)[0]
+	}
+	result := semver.Compare(version, cmp.version)
+	switch cmp.op {
+	case "=":
+		return result == 0
+	case "!=":
+		return result != 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	}
+	return false
+}
+
+// Check returns true if version satisfies the constraint.
+func (c *Constraint) Check(version string) bool {
+	version, err := canonicalVersion(version)
+	if err != nil {
+		return false
+	}
+	for _, comparisons := range c.alternatives {
+		match := true
+		for _, cmp := range comparisons {
+			if !cmp.check(version) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// DownloadVersion returns the version to download when no installed version
+// satisfies the constraint. This is the lower bound of the first alternative,
+// as written in the expression. It has to be a complete version that
+// satisfies the constraint; ranges like "<1.5" or "~1.4" don't name a release,
+// and are an error.
+func (c *Constraint) DownloadVersion() (string, error) {
+	for _, cmp := range c.alternatives[0] {
+		if cmp.op != "=" && cmp.op != ">=" {
+			continue
+		}
+		if _, n := versionParts(cmp.version); n < 3 || !c.Check(cmp.version) {
+			break
+		}
+		return cmp.literal, nil
+	}
+	return "", fmt.Errorf("version constraint %q does not name a release to download", c.expr)
+}
@@ -0,0 +1,88 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstraintCheck(t *testing.T) {
+	testCases := []struct {
+		constraint string
+		version    string
+		match      bool
+	}{
+		{"1.4.1", "1.4.1", true},
+		{"1.4.1", "v1.5.0", true},
+		{"1.4.1", "1.4.0", false},
+		{"=1.4.1", "1.4.1", true},
+		{"=1.4.1", "1.4.2", false},
+		{"=1.4.1", "v1.4.1-12-gabcdef", true},
+		{"=1.4.1-rc1", "1.4.1-rc1", true},
+		{"=1.4.1-rc1", "1.4.1", false},
+		{"~1.4", "1.4.0", true},
+		{"~1.4", "1.4.9", true},
+		{"~1.4", "1.5.0", false},
+		{"~1.4", "1.5.0-3-gabcdef", false},
+		{"~1.4.2", "1.4.1", false},
+		{"~1", "1.9.0", true},
+		{"^1.4", "1.9.0", true},
+		{"^1.4", "2.0.0", false},
+		{"^0.4.2", "0.4.9", true},
+		{"^0.4.2", "0.5.0", false},
+		{">=1.3.2 <1.5", "1.3.2", true},
+		{">=1.3.2 <1.5", "1.4.9", true},
+		{">=1.3.2 <1.5", "1.5.0", false},
+		{">=1.3.2, <1.5", "1.3.1", false},
+		{">= 1.3.2 < 1.5", "1.4.0", true},
+		{"!=1.4.1", "1.4.1", false},
+		{"=1.2.0 || =1.4.1", "1.4.1", true},
+		{"=1.2.0 || =1.4.1", "1.3.0", false},
+		{"1.0.0", "not-a-version", false},
+	}
+	for _, tc := range testCases {
+		c, err := ParseConstraint(tc.constraint)
+		assert.NoError(t, err, tc.constraint)
+		assert.Equal(t, tc.match, c.Check(tc.version), "%s %s", tc.constraint, tc.version)
+	}
+}
+
+func TestParseConstraintInvalid(t *testing.T) {
+	for _, expr := range []string{"", "latest", "arm-latest", ">=", "~foo", "1.2 ||"} {
+		_, err := ParseConstraint(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestConstraintDownloadVersion(t *testing.T) {
+	testCases := []struct {
+		constraint string
+		version    string
+		failure    bool
+	}{
+		{constraint: "1.4.1", version: "1.4.1"},
+		{constraint: "=v1.4.1", version: "v1.4.1"},
+		{constraint: ">=1.3.2 <1.5", version: "1.3.2"},
+		{constraint: "=1.2.0 || =1.4.1", version: "1.2.0"},
+		{constraint: "<1.5", failure: true},
+		{constraint: ">1.3", failure: true},
+		{constraint: "!=1.4.0", failure: true},
+		{constraint: "~1.4", failure: true},
+		{constraint: "^1.4", failure: true},
+		{constraint: "~1.4.2", version: "1.4.2"},
+		{constraint: "1.4", failure: true},
+		{constraint: ">=1.3 <1.5", failure: true},
+		{constraint: ">=1.5.0 <1.5.0", failure: true},
+	}
+	for _, tc := range testCases {
+		c, err := ParseConstraint(tc.constraint)
+		assert.NoError(t, err)
+		version, err := c.DownloadVersion()
+		if tc.failure {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, tc.version, version)
+	}
+}
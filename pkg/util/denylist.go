@@ -0,0 +1,91 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// Denylist records binaries known to be bad by their SHA-256 digest, so they
+// are not installed again.
+type Denylist struct {
+	path string
+}
+
+func NewDenylist(path string) *Denylist {
+	return &Denylist{
+		path: path,
+	}
+}
+
+func (d *Denylist) digests() (map[string]string, error) {
+	digests := make(map[string]string)
+	contents, err := ioutil.ReadFile(d.path)
+	if err != nil && os.IsNotExist(err) {
+		return digests, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", d.path, err)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 1 {
+			continue
+		}
+		version := ""
+		if len(fields) > 1 {
+			version = fields[1]
+		}
+		digests[fields[0]] = version
+	}
+	return digests, nil
+}
+
+// Add records the binary at path as bad. The version is only informational.
+func (d *Denylist) Add(path, version string) error {
+	digest, err := FileDigest(path)
+	if err != nil {
+		return err
+	}
+	_ = os.MkdirAll(filepath.Dir(d.path), 0755)
+	f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", d.path, err)
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s %s\n", digest, version)
+	if err != nil {
+		return fmt.Errorf("writing %s: %v", d.path, err)
+	}
+	klog.Warningf("added %s version %q (%s) to denylist %s",
+		path, version, digest, d.path)
+	return nil
+}
+
+// Check returns an error if the binary at path is on the denylist.
+func (d *Denylist) Check(path string) error {
+	digests, err := d.digests()
+	if err != nil {
+		return err
+	}
+	digest, err := FileDigest(path)
+	if err != nil {
+		return err
+	}
+	if version, found := digests[digest]; found {
+		return &DeniedError{Digest: digest, Version: version}
+	}
+	return nil
+}
+
+type DeniedError struct {
+	Digest  string
+	Version string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("%s (version %q) is on the denylist", e.Digest, e.Version)
+}
@@ -0,0 +1,542 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elotl/itzo-launcher/pkg/metrics"
+	"k8s.io/klog"
+)
+
+var (
+	DialTimeout         = time.Duration(5 * time.Second)
+	DownloadRetries     = 5
+	DownloadTimeout     = time.Duration(10 * time.Minute)
+	DownloadBackoffBase = time.Duration(1 * time.Second)
+	DownloadBackoffMax  = time.Duration(30 * time.Second)
+)
+
+// retryableError marks errors that are likely transient, e.g. a reset
+// connection or a 5xx response.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func isRetryable(err error) bool {
+	_, ok := err.(*retryableError)
+	return ok
+}
+
+// notFoundError is returned by sources when the requested object does not
+// exist.
+type notFoundError struct {
+	url string
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("%s not found", e.url)
+}
+
+// source is a location a file can be downloaded from.
+type source interface {
+	// Open returns the contents of the file starting at offset. If the
+	// source is not able to resume a download at offset, it returns the
+	// whole file and false.
+	Open(ctx context.Context, offset int64) (io.ReadCloser, bool, error)
+}
+
+// Validators identify a version of a remote file.
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+func (v Validators) IsEmpty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// ifRange returns the value to use for If-Range. Weak ETags can't be used in
+// If-Range.
+func (v Validators) ifRange() string {
+	if v.ETag != "" && !strings.HasPrefix(v.ETag, "W/") {
+		return v.ETag
+	}
+	return v.LastModified
+}
+
+// conditionalSource is implemented by sources that are able to tell if a
+// file has changed since it was downloaded.
+type conditionalSource interface {
+	// Validators returns the validators of the last complete download.
+	Validators() Validators
+	// Modified returns false if the file still matches validators.
+	Modified(ctx context.Context, validators Validators) (bool, error)
+}
+
+// resumableSource is implemented by sources that are able to resume a
+// download started by another source for the same URL, e.g. in an earlier run
+// of the launcher.
+type resumableSource interface {
+	conditionalSource
+	// Resume makes Open only resume a download if the remote file still
+	// matches validators, which were returned by Validators when the
+	// download started.
+	Resume(validators Validators)
+}
+
+// partialDownload is stored next to a file being downloaded, so the download
+// can be resumed after a restart.
+type partialDownload struct {
+	URL        string     `json:"url"`
+	Validators Validators `json:"validators"`
+}
+
+func partialPath(path string) string {
+	return path + ".resume"
+}
+
+// readPartialDownload returns the validators of the partial download of url
+// at path, or empty validators if there is none.
+func readPartialDownload(url, path string) Validators {
+	contents, err := ioutil.ReadFile(partialPath(path))
+	if err != nil {
+		return Validators{}
+	}
+	partial := partialDownload{}
+	err = json.Unmarshal(contents, &partial)
+	if err != nil || partial.URL != url {
+		return Validators{}
+	}
+	return partial.Validators
+}
+
+func writePartialDownload(url, path string, validators Validators) {
+	contents, err := json.Marshal(&partialDownload{
+		URL:        url,
+		Validators: validators,
+	})
+	if err == nil {
+		err = ioutil.WriteFile(partialPath(path), contents, 0644)
+	}
+	if err != nil {
+		klog.Warningf("recording partial download of %s: %v", url, err)
+	}
+}
+
+// contentVerifier is implemented by sources that are able to check the
+// integrity of the downloaded contents themselves.
+type contentVerifier interface {
+	VerifyContent(r io.Reader) error
+}
+
+// newSource creates a source based on the scheme of rawurl. Supported schemes
+// are http://, https://, file://, s3:// and oci:// (or oci+http:// for
+// registries without TLS).
+func newSource(rawurl string) (source, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("parsing URL %q: %v", rawurl, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		client, err := newHTTPClient()
+		if err != nil {
+			return nil, err
+		}
+		return &httpSource{
+			client: client,
+			url:    rawurl,
+		}, nil
+	case "file":
+		return &fileSource{
+			path: u.Path,
+		}, nil
+	case "s3":
+		return newS3Source(u)
+	case "oci", "oci+http":
+		return newOCISource(rawurl)
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme in %q", rawurl)
+	}
+}
+
+func urlScheme(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme
+}
+
+// backoff returns the jittered delay before retry number attempt.
+func backoff(attempt int) time.Duration {
+	d := DownloadBackoffBase << uint(attempt)
+	if d <= 0 || d > DownloadBackoffMax {
+		d = DownloadBackoffMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// withRetries calls fn until it succeeds or returns an error that is not
+// retryable, backing off exponentially between attempts. It gives up after
+// DownloadRetries retries, or when ctx is done.
+func withRetries(ctx context.Context, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn(ctx)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt >= DownloadRetries {
+			break
+		}
+		delay := backoff(attempt)
+		metrics.DownloadRetries.Inc()
+		klog.Warningf("%v; retrying in %v (%d/%d)",
+			err, delay, attempt+1, DownloadRetries)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: %v", err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+	return fmt.Errorf("giving up after %d retries: %v", DownloadRetries, err)
+}
+
+func statusError(url string, statusCode int) error {
+	err := fmt.Errorf("downloading %s: got status code %d", url, statusCode)
+	if statusCode >= 500 ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode == http.StatusRequestTimeout {
+		return &retryableError{err}
+	}
+	return err
+}
+
+func doGet(ctx context.Context, client *http.Client, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating get request for %s: %+v", url, err)
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("getting %s: %+v", url, err)
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &retryableError{err}
+	}
+	return resp, nil
+}
+
+// parseContentRangeStart returns the first byte position from a Content-Range
+// header value, e.g. "bytes 100-199/200".
+func parseContentRangeStart(contentRange string) (int64, error) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(contentRange, "bytes "), "-", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	return strconv.ParseInt(parts[0], 10, 64)
+}
+
+// openHTTPRange gets url, requesting the bytes from offset if offset is not
+// zero. The validators are used to make sure the remote file has not changed
+// since the beginning of the download; they get updated when the whole file
+// is returned.
+func openHTTPRange(ctx context.Context, client *http.Client, url string, header http.Header, offset int64, validators *Validators) (io.ReadCloser, bool, error) {
+	if header == nil {
+		header = http.Header{}
+	}
+	if offset > 0 && validators.ifRange() != "" {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		header.Set("If-Range", validators.ifRange())
+	}
+	resp, err := doGet(ctx, client, url, header)
+	if err != nil {
+		return nil, false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		*validators = Validators{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+		return resp.Body, false, nil
+	case http.StatusPartialContent:
+		start, err := parseContentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			resp.Body.Close()
+			// Start over with the whole file.
+			*validators = Validators{}
+			return nil, false, &retryableError{fmt.Errorf(
+				"downloading %s: unexpected range %q for offset %d",
+				url, resp.Header.Get("Content-Range"), offset)}
+		}
+		return resp.Body, true, nil
+	case http.StatusNotFound, http.StatusForbidden:
+		// S3 returns 403 for missing objects in buckets without list access.
+		resp.Body.Close()
+		klog.V(2).Infof("%s not found: got status code %d",
+			url, resp.StatusCode)
+		return nil, false, &notFoundError{url}
+	default:
+		resp.Body.Close()
+		return nil, false, statusError(url, resp.StatusCode)
+	}
+}
+
+// httpModified sends a conditional HEAD request for url.
+func httpModified(ctx context.Context, client *http.Client, url string, header http.Header, validators Validators) (bool, error) {
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return true, fmt.Errorf("creating head request for %s: %+v", url, err)
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("checking %s: %+v", url, err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+		return true, nil
+	default:
+		return true, fmt.Errorf("checking %s: got status code %d", url, resp.StatusCode)
+	}
+}
+
+type httpSource struct {
+	client     *http.Client
+	url        string
+	validators Validators
+}
+
+func (s *httpSource) Open(ctx context.Context, offset int64) (io.ReadCloser, bool, error) {
+	return openHTTPRange(ctx, s.client, s.url, nil, offset, &s.validators)
+}
+
+func (s *httpSource) Validators() Validators {
+	return s.validators
+}
+
+func (s *httpSource) Resume(validators Validators) {
+	s.validators = validators
+}
+
+func (s *httpSource) Modified(ctx context.Context, validators Validators) (bool, error) {
+	return httpModified(ctx, s.client, s.url, nil, validators)
+}
+
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) Open(ctx context.Context, offset int64) (io.ReadCloser, bool, error) {
+	f, err := os.Open(s.path)
+	if err != nil && os.IsNotExist(err) {
+		return nil, false, &notFoundError{s.path}
+	} else if err != nil {
+		return nil, false, fmt.Errorf("opening %s: %+v", s.path, err)
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return nil, false, fmt.Errorf("seeking %s: %+v", s.path, err)
+	}
+	return f, true, nil
+}
+
+// FetchSmallFile downloads the file at url, which is expected to be at most
+// limit bytes long. If the file does not exist, nil is returned without an
+// error.
+func FetchSmallFile(url string, limit int64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DownloadTimeout)
+	defer cancel()
+	src, err := newSource(url)
+	if err != nil {
+		return nil, err
+	}
+	var contents []byte
+	err = withRetries(ctx, func(ctx context.Context) error {
+		contents = nil
+		body, _, err := src.Open(ctx, 0)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		contents, err = ioutil.ReadAll(io.LimitReader(body, limit+1))
+		if err != nil {
+			return &retryableError{fmt.Errorf("reading %s: %+v", url, err)}
+		}
+		if int64(len(contents)) > limit {
+			return fmt.Errorf("%s is larger than %d bytes", url, limit)
+		}
+		return nil
+	})
+	if _, ok := err.(*notFoundError); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if verifier, ok := src.(contentVerifier); ok {
+		err = verifier.VerifyContent(bytes.NewReader(contents))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return contents, nil
+}
+
+// remoteModified checks if url has changed since it was downloaded with
+// validators. If that can't be determined, the file is assumed to have
+// changed.
+func remoteModified(url string, validators Validators) bool {
+	src, err := newSource(url)
+	if err != nil {
+		return true
+	}
+	conditional, ok := src.(conditionalSource)
+	if !ok {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DownloadTimeout)
+	defer cancel()
+	modified, err := conditional.Modified(ctx, validators)
+	if err != nil {
+		klog.Warningf("%v", err)
+		return true
+	}
+	return modified
+}
+
+// downloadFile downloads url into path. Transient errors are retried, and if
+// a previous attempt got interrupted, the download is resumed if the source
+// supports it. That includes downloads left behind in path by an earlier run,
+// as long as the remote file has not changed since. The whole download has to
+// finish before DownloadTimeout. The validators of the downloaded file are
+// returned if the source provides them.
+func downloadFile(url, path string) (validators Validators, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.DownloadDuration.WithLabelValues(
+			urlScheme(url), metrics.Result(err)).Observe(
+			time.Since(start).Seconds())
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), DownloadTimeout)
+	defer cancel()
+	src, err := newSource(url)
+	if err != nil {
+		return Validators{}, err
+	}
+	// Keep what an earlier run downloaded only if the source can make sure
+	// the rest comes from the same version of the file.
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resumable, ok := src.(resumableSource); ok {
+		if previous := readPartialDownload(url, path); !previous.IsEmpty() {
+			resumable.Resume(previous)
+			flags &^= os.O_TRUNC
+		}
+	}
+	f, err := os.OpenFile(path, flags, 0755)
+	if err != nil {
+		return Validators{}, fmt.Errorf("opening %s for writing: %+v", path, err)
+	}
+	f.Close()
+	err = withRetries(ctx, func(ctx context.Context) error {
+		return downloadRange(ctx, src, url, path)
+	})
+	if err != nil {
+		return Validators{}, err
+	}
+	os.Remove(partialPath(path))
+	if verifier, ok := src.(contentVerifier); ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return Validators{}, fmt.Errorf("opening %s: %+v", path, err)
+		}
+		defer f.Close()
+		err = verifier.VerifyContent(f)
+		if err != nil {
+			return Validators{}, err
+		}
+	}
+	if conditional, ok := src.(conditionalSource); ok {
+		validators = conditional.Validators()
+	}
+	return validators, nil
+}
+
+// downloadRange fetches the part of the file that is missing from path.
+func downloadRange(ctx context.Context, src source, url, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("checking %s: %+v", path, err)
+	}
+	offset := fi.Size()
+	body, resumed, err := src.Open(ctx, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	flags := os.O_WRONLY
+	if resumed {
+		flags |= os.O_APPEND
+		if offset > 0 {
+			klog.V(2).Infof("resuming download of %s at offset %d", url, offset)
+		}
+	} else {
+		flags |= os.O_TRUNC
+		offset = 0
+		if resumable, ok := src.(resumableSource); ok {
+			writePartialDownload(url, path, resumable.Validators())
+		}
+	}
+	f, err := os.OpenFile(path, flags, 0755)
+	if err != nil {
+		return fmt.Errorf("opening %s for writing: %+v", path, err)
+	}
+	defer f.Close()
+	n, err := io.Copy(f, body)
+	metrics.DownloadBytes.WithLabelValues(urlScheme(url)).Add(float64(n))
+	if err != nil {
+		err = fmt.Errorf("writing %s after %d bytes: %+v", path, offset+n, err)
+		if ctx.Err() != nil {
+			return err
+		}
+		return &retryableError{err}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	maxManifestSize         = 4 * 1024 * 1024
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociSource downloads a file stored as an artifact in an OCI registry, e.g.
+// oci://registry.example.com/elotl/itzo:v1.2.3. The file is the first layer
+// of the artifact. Only anonymous access and bearer tokens handed out
+// anonymously by the registry are supported.
+type ociSource struct {
+	url        string
+	client     *http.Client
+	baseURL    string
+	repository string
+	reference  string
+	token      string
+	layer      *ociDescriptor
+	validators Validators
+}
+
+// parseOCIReference splits a reference like
+// oci://registry/repo/name:tag or oci://registry/repo/name@sha256:... into the
+// registry API base URL, the repository and the tag or digest.
+func parseOCIReference(rawurl string) (string, string, string, error) {
+	scheme := "https"
+	rest := ""
+	if strings.HasPrefix(rawurl, "oci+http://") {
+		scheme = "http"
+		rest = strings.TrimPrefix(rawurl, "oci+http://")
+	} else if strings.HasPrefix(rawurl, "oci://") {
+		rest = strings.TrimPrefix(rawurl, "oci://")
+	} else {
+		return "", "", "", fmt.Errorf("invalid OCI reference %q", rawurl)
+	}
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("invalid OCI reference %q", rawurl)
+	}
+	registry, repository := parts[0], parts[1]
+	reference := "latest"
+	if i := strings.Index(repository, "@"); i >= 0 {
+		reference = repository[i+1:]
+		repository = repository[:i]
+	} else if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		reference = repository[i+1:]
+		repository = repository[:i]
+	}
+	if repository == "" || reference == "" {
+		return "", "", "", fmt.Errorf("invalid OCI reference %q", rawurl)
+	}
+	return fmt.Sprintf("%s://%s/v2", scheme, registry), repository, reference, nil
+}
+
+func newOCISource(rawurl string) (*ociSource, error) {
+	baseURL, repository, reference, err := parseOCIReference(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &ociSource{
+		url:        rawurl,
+		client:     client,
+		baseURL:    baseURL,
+		repository: repository,
+		reference:  reference,
+	}, nil
+}
+
+// parseBearerChallenge parses the parameters of a WWW-Authenticate header
+// like: Bearer realm="https://auth.example.com/token",service="registry".
+func parseBearerChallenge(challenge string) (map[string]string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return nil, fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(challenge[len("bearer "):], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	if params["realm"] == "" {
+		return nil, fmt.Errorf("no realm in authentication challenge %q", challenge)
+	}
+	return params, nil
+}
+
+// authenticate fetches an anonymous bearer token as requested by the
+// registry.
+func (s *ociSource) authenticate(ctx context.Context, challenge string) error {
+	params, err := parseBearerChallenge(challenge)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(params["realm"])
+	if err != nil {
+		return fmt.Errorf("parsing token realm %q: %v", params["realm"], err)
+	}
+	q := u.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", s.repository)
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+	resp, err := doGet(ctx, s.client, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(u.String(), resp.StatusCode)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&token)
+	if err != nil {
+		return fmt.Errorf("decoding token from %s: %v", u.String(), err)
+	}
+	s.token = token.Token
+	if s.token == "" {
+		s.token = token.AccessToken
+	}
+	if s.token == "" {
+		return fmt.Errorf("got no token from %s", u.String())
+	}
+	return nil
+}
+
+func (s *ociSource) header(accept string) http.Header {
+	header := http.Header{}
+	if accept != "" {
+		header.Set("Accept", accept)
+	}
+	if s.token != "" {
+		header.Set("Authorization", "Bearer "+s.token)
+	}
+	return header
+}
+
+// resolve looks up the layer holding the file.
+func (s *ociSource) resolve(ctx context.Context) error {
+	if s.layer != nil {
+		return nil
+	}
+	manifestURL := fmt.Sprintf("%s/%s/manifests/%s", s.baseURL, s.repository, s.reference)
+	accept := ociManifestMediaType + ", " + dockerManifestMediaType
+	resp, err := doGet(ctx, s.client, manifestURL, s.header(accept))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized && s.token == "" {
+		err = s.authenticate(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return fmt.Errorf("authenticating to %s: %w", s.baseURL, err)
+		}
+		return s.resolve(ctx)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		klog.V(2).Infof("%s not found: got status code %d", s.url, resp.StatusCode)
+		return &notFoundError{s.url}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return statusError(manifestURL, resp.StatusCode)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return &retryableError{fmt.Errorf("reading %s: %v", manifestURL, err)}
+	}
+	manifest := ociManifest{}
+	err = json.Unmarshal(buf, &manifest)
+	if err != nil {
+		return fmt.Errorf("decoding manifest %s: %v", manifestURL, err)
+	}
+	if len(manifest.Layers) < 1 {
+		return fmt.Errorf("no layers found in %s", s.url)
+	}
+	s.layer = &manifest.Layers[0]
+	if !strings.HasPrefix(s.layer.Digest, "sha256:") {
+		return fmt.Errorf("unsupported layer digest %q in %s", s.layer.Digest, s.url)
+	}
+	klog.V(2).Infof("resolved %s to layer %s", s.url, s.layer.Digest)
+	return nil
+}
+
+func (s *ociSource) Open(ctx context.Context, offset int64) (io.ReadCloser, bool, error) {
+	err := s.resolve(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	blobURL := fmt.Sprintf("%s/%s/blobs/%s", s.baseURL, s.repository, s.layer.Digest)
+	return openHTTPRange(ctx, s.client, blobURL, s.header(""), offset, &s.validators)
+}
+
+// VerifyContent checks the contents against the digest of the layer.
+func (s *ociSource) VerifyContent(r io.Reader) error {
+	if s.layer == nil {
+		return fmt.Errorf("%s has not been resolved", s.url)
+	}
+	hash := sha256.New()
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", s.url, err)
+	}
+	actual := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	if actual != s.layer.Digest {
+		return fmt.Errorf("digest mismatch for %s: expected %s, got %s",
+			s.url, s.layer.Digest, actual)
+	}
+	return nil
+}
+
+// Validators returns the digest of the layer as the ETag: blobs are content
+// addressed, so the digest identifies the file.
+func (s *ociSource) Validators() Validators {
+	if s.layer == nil {
+		return Validators{}
+	}
+	return Validators{
+		ETag: s.layer.Digest,
+	}
+}
+
+func (s *ociSource) Modified(ctx context.Context, validators Validators) (bool, error) {
+	err := s.resolve(ctx)
+	if err != nil {
+		return true, err
+	}
+	return s.layer.Digest != validators.ETag, nil
+}
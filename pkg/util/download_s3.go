@@ -0,0 +1,167 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"k8s.io/klog"
+)
+
+// s3Source downloads objects from S3 using the credentials available to the
+// instance, so the bucket does not need to be public.
+type s3Source struct {
+	url          string
+	bucket       string
+	key          string
+	client       *s3.S3
+	etag         string
+	lastModified string
+}
+
+func newS3Source(u *url.URL) (*s3Source, error) {
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q", u.String())
+	}
+	return &s3Source{
+		url:    u.String(),
+		bucket: u.Host,
+		key:    key,
+	}, nil
+}
+
+func (s *s3Source) getClient(ctx context.Context) (*s3.S3, error) {
+	if s.client != nil {
+		return s.client, nil
+	}
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(aws.NewConfig().WithHTTPClient(httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("creating AWS session: %v", err)
+	}
+	regionHint := os.Getenv("AWS_REGION")
+	if regionHint == "" {
+		regionHint = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if regionHint == "" {
+		regionHint = "us-east-1"
+	}
+	region, err := s3manager.GetBucketRegion(ctx, sess, s.bucket, regionHint)
+	if err != nil {
+		return nil, s3Error(s.url, fmt.Errorf(
+			"detecting region of bucket %s: %w", s.bucket, err))
+	}
+	klog.V(2).Infof("S3 bucket %s is in region %s", s.bucket, region)
+	s.client = s3.New(sess, aws.NewConfig().WithRegion(region))
+	return s.client, nil
+}
+
+// s3Error classifies errors returned by the AWS SDK.
+func s3Error(url string, err error) error {
+	var reqErr awserr.RequestFailure
+	if !errors.As(err, &reqErr) {
+		return &retryableError{err}
+	}
+	switch code := reqErr.StatusCode(); {
+	case code == http.StatusNotFound || code == http.StatusForbidden:
+		klog.V(2).Infof("%s not found: %v", url, err)
+		return &notFoundError{url}
+	case code >= 500 || code == http.StatusTooManyRequests:
+		return &retryableError{err}
+	default:
+		return fmt.Errorf("downloading %s: %v", url, err)
+	}
+}
+
+func (s *s3Source) Open(ctx context.Context, offset int64) (io.ReadCloser, bool, error) {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	}
+	resume := offset > 0 && s.etag != ""
+	if resume {
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+		in.IfMatch = aws.String(s.etag)
+	}
+	out, err := client.GetObjectWithContext(ctx, in)
+	if err != nil {
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) &&
+			reqErr.StatusCode() == http.StatusPreconditionFailed {
+			// The object has changed, start over.
+			s.etag = ""
+			return nil, false, &retryableError{fmt.Errorf(
+				"downloading %s: object changed during download", s.url)}
+		}
+		return nil, false, s3Error(s.url, err)
+	}
+	if resume {
+		start, err := parseContentRangeStart(aws.StringValue(out.ContentRange))
+		if err != nil || start != offset {
+			out.Body.Close()
+			s.etag = ""
+			return nil, false, &retryableError{fmt.Errorf(
+				"downloading %s: unexpected range %q for offset %d",
+				s.url, aws.StringValue(out.ContentRange), offset)}
+		}
+		return out.Body, true, nil
+	}
+	s.etag = aws.StringValue(out.ETag)
+	s.lastModified = ""
+	if out.LastModified != nil {
+		s.lastModified = out.LastModified.UTC().Format(http.TimeFormat)
+	}
+	return out.Body, false, nil
+}
+
+func (s *s3Source) Validators() Validators {
+	return Validators{
+		ETag:         s.etag,
+		LastModified: s.lastModified,
+	}
+}
+
+func (s *s3Source) Resume(validators Validators) {
+	s.etag = validators.ETag
+	s.lastModified = validators.LastModified
+}
+
+func (s *s3Source) Modified(ctx context.Context, validators Validators) (bool, error) {
+	if validators.ETag == "" {
+		return true, nil
+	}
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return true, err
+	}
+	_, err = client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key),
+		IfNoneMatch: aws.String(validators.ETag),
+	})
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotModified {
+		return false, nil
+	} else if err != nil {
+		return true, fmt.Errorf("checking %s: %v", s.url, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,256 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fastRetries makes retries back off quickly, and returns a function that
+// restores the previous settings.
+func fastRetries() func() {
+	base, max := DownloadBackoffBase, DownloadBackoffMax
+	DownloadBackoffBase = time.Millisecond
+	DownloadBackoffMax = 10 * time.Millisecond
+	return func() {
+		DownloadBackoffBase, DownloadBackoffMax = base, max
+	}
+}
+
+func TestDownloadFileRetryAndResume(t *testing.T) {
+	defer fastRetries()()
+	content := bytes.Repeat([]byte("itzo"), 1024)
+	requests := 0
+	ranges := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			ranges = append(ranges, r.Header.Get("Range"))
+			switch requests {
+			case 1:
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case 2:
+				// Truncated response.
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Content-Length", "4096")
+				w.Write(content[:1000])
+			default:
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "itzo", time.Time{}, bytes.NewReader(content))
+			}
+		}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "itzo")
+
+	_, err = downloadFile(server.URL+"/itzo", path)
+	assert.NoError(t, err)
+	buf, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, buf)
+	assert.Equal(t, []string{"", "", "bytes=1000-"}, ranges)
+}
+
+func TestDownloadFileResumeAfterRestart(t *testing.T) {
+	defer fastRetries()()
+	defer func(retries int) {
+		DownloadRetries = retries
+	}(DownloadRetries)
+	content := bytes.Repeat([]byte("itzo"), 1024)
+	etag := `"v1"`
+	truncate := true
+	ranges := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ranges = append(ranges, r.Header.Get("Range"))
+			w.Header().Set("ETag", etag)
+			if truncate {
+				w.Header().Set("Content-Length", "4096")
+				w.Write(content[:1000])
+				return
+			}
+			http.ServeContent(w, r, "itzo", time.Time{}, bytes.NewReader(content))
+		}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "itzo")
+
+	// The first run gives up, leaving a partial download behind.
+	DownloadRetries = 0
+	_, err = downloadFile(server.URL+"/itzo", path)
+	assert.Error(t, err)
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), fi.Size())
+
+	// The next one picks up where it stopped.
+	truncate = false
+	_, err = downloadFile(server.URL+"/itzo", path)
+	assert.NoError(t, err)
+	buf, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, buf)
+	assert.Equal(t, []string{"", "bytes=1000-"}, ranges)
+	_, err = os.Stat(partialPath(path))
+	assert.True(t, os.IsNotExist(err))
+
+	// A partial download of a file that has changed since is discarded.
+	ranges = ranges[:0]
+	truncate = true
+	_, err = downloadFile(server.URL+"/itzo", path)
+	assert.Error(t, err)
+	truncate = false
+	etag = `"v2"`
+	_, err = downloadFile(server.URL+"/itzo", path)
+	assert.NoError(t, err)
+	buf, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, buf)
+	assert.Equal(t, []string{"", "bytes=1000-"}, ranges)
+}
+
+func TestDownloadFileNotRetryable(t *testing.T) {
+	defer fastRetries()()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusNotFound)
+		}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = downloadFile(server.URL+"/itzo", filepath.Join(dir, "itzo"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, requests)
+}
+
+func TestDownloadFileScheme(t *testing.T) {
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "itzo-latest")
+	err = ioutil.WriteFile(src, []byte("itzo"), 0755)
+	assert.NoError(t, err)
+
+	dst := filepath.Join(dir, "itzo")
+	_, err = downloadFile("file://"+src, dst)
+	assert.NoError(t, err)
+	buf, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "itzo", string(buf))
+
+	contents, err := FetchSmallFile("file://"+src+".sha256", 4096)
+	assert.NoError(t, err)
+	assert.Nil(t, contents)
+}
+
+func TestParseOCIReference(t *testing.T) {
+	testCases := []struct {
+		url        string
+		baseURL    string
+		repository string
+		reference  string
+		failure    bool
+	}{
+		{
+			url:        "oci://registry.example.com/elotl/itzo:v1.2.3",
+			baseURL:    "https://registry.example.com/v2",
+			repository: "elotl/itzo",
+			reference:  "v1.2.3",
+		},
+		{
+			url:        "oci+http://localhost:5000/itzo",
+			baseURL:    "http://localhost:5000/v2",
+			repository: "itzo",
+			reference:  "latest",
+		},
+		{
+			url:        "oci://registry.example.com/itzo@sha256:abcd",
+			baseURL:    "https://registry.example.com/v2",
+			repository: "itzo",
+			reference:  "sha256:abcd",
+		},
+		{
+			url:     "oci://registry.example.com",
+			failure: true,
+		},
+	}
+	for _, tc := range testCases {
+		baseURL, repository, reference, err := parseOCIReference(tc.url)
+		if tc.failure {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.baseURL, baseURL)
+		assert.Equal(t, tc.repository, repository)
+		assert.Equal(t, tc.reference, reference)
+	}
+}
+
+func TestDownloadFileOCI(t *testing.T) {
+	content := []byte("itzo artifact")
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/token" {
+				assert.Equal(t, "repository:elotl/itzo:pull", r.URL.Query().Get("scope"))
+				w.Write([]byte(`{"token": "secret"}`))
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.Header().Set("WWW-Authenticate",
+					`Bearer realm="`+server.URL+`/token",service="test"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/v2/elotl/itzo/manifests/v1.0.0":
+				w.Header().Set("Content-Type", ociManifestMediaType)
+				w.Write([]byte(`{"schemaVersion": 2, "layers": [{"mediaType": "application/octet-stream", "digest": "` + digest + `"}]}`))
+			case "/v2/elotl/itzo/blobs/" + digest:
+				w.Write(content)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "itzo")
+	ref := strings.Replace(server.URL, "http://", "oci+http://", 1) + "/elotl/itzo"
+
+	_, err = downloadFile(ref+":v1.0.0", dst)
+	assert.NoError(t, err)
+	buf, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, content, buf)
+
+	contents, err := FetchSmallFile(ref+":v1.0.0.sha256", 4096)
+	assert.NoError(t, err)
+	assert.Nil(t, contents)
+}
@@ -17,18 +17,18 @@ limitations under the License.
 package util
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
-	"net"
-	"net/http"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 
-	"golang.org/x/mod/semver"
 	"k8s.io/klog"
 )
 
@@ -40,45 +40,43 @@ const (
 
 var (
 	semverRegex = regexp.MustCompile("^" + semverRegexFmt + "$")
-	DialTimeout = time.Duration(5 * time.Second)
 )
 
-func versionMatch(exe, minVersion, versionArg string) bool {
-	if !semverRegex.Match([]byte(minVersion)) {
-		klog.Warningf("invalid minimum version requested %q", minVersion)
-		return false
-	}
-	if minVersion[0] != 'v' {
-		minVersion = "v" + minVersion
-	}
+// ProgVersion runs exe with versionArg, and returns the first semver version
+// found in its output.
+func ProgVersion(exe, versionArg string) (string, error) {
 	cmd := exec.Command(exe, versionArg)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		klog.V(2).Infof("%q error getting version: %v", exe, err)
-		return false
+		return "", fmt.Errorf("%q error getting version: %v", exe, err)
 	}
-	version := ""
 	lines := strings.Split(string(output), "\n")
 	for _, line := range lines {
 		for _, word := range strings.Fields(line) {
 			word = strings.TrimRight(word, ",;.")
 			if semverRegex.Match([]byte(word)) {
-				version = word
-				klog.V(2).Infof("%q found version %q (minimum requested: %q)", exe, version, minVersion)
-				if version[0] != 'v' {
-					version = "v" + version
-				}
-				// Version reporting in itzo is broken: it reports its build
-				// hash as part of the prelease version.
-				if !strings.Contains(minVersion, "-") {
-					version = strings.Split(version, "-")[0]
-				}
-				return semver.Compare(version, minVersion) >= 0
+				return word, nil
 			}
 		}
 	}
-	klog.V(2).Infof("%q not found version in output %q", exe, output)
-	return false
+	return "", fmt.Errorf("%q not found version in output %q", exe, output)
+}
+
+// VersionMatch returns true if the version reported by exe satisfies the
+// version constraint expression.
+func VersionMatch(exe, constraint, versionArg string) bool {
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		klog.Warningf("invalid version requested: %v", err)
+		return false
+	}
+	version, err := ProgVersion(exe, versionArg)
+	if err != nil {
+		klog.V(2).Infof("%v", err)
+		return false
+	}
+	klog.V(2).Infof("%q found version %q (requested: %q)", exe, version, constraint)
+	return c.Check(version)
 }
 
 func ensurePath(localPath string) {
@@ -91,7 +89,10 @@ func ensurePath(localPath string) {
 	os.Setenv("PATH", envPath+":"+localPath)
 }
 
-func EnsureProg(prog, downloadURL, minVersion, versionArg string) (string, error) {
+// FindProg looks up prog and checks that its version satisfies the version
+// constraint. It returns the path to the executable, or an empty string if no
+// suitable version is installed.
+func FindProg(prog, constraint, versionArg string) (string, error) {
 	progBase := filepath.Base(prog)
 	progDir := filepath.Dir(prog)
 	if progDir == "" || progDir == "." {
@@ -100,52 +101,219 @@ func EnsureProg(prog, downloadURL, minVersion, versionArg string) (string, error
 	ensurePath(progDir)
 	exe, err := exec.LookPath(progBase)
 	if err == nil {
-		found := versionMatch(exe, minVersion, versionArg)
-		klog.V(5).Infof("looking for %s %s: found %v", exe, minVersion, found)
+		found := VersionMatch(exe, constraint, versionArg)
+		klog.V(5).Infof("looking for %s %s: found %v", exe, constraint, found)
 		if found {
 			return exe, nil
 		}
 	}
-	exe = filepath.Join(progDir, progBase)
-	err = InstallProg(downloadURL, exe)
+	return "", nil
+}
+
+type InstallOptions struct {
+	// Digest is the expected hex encoded SHA-256 digest of the downloaded
+	// file. No checksum verification is done if it's empty.
+	Digest string
+	// Verify is called with the path of the downloaded file before it gets
+	// installed. The file is discarded if it returns an error.
+	Verify func(path string) error
+	// KeepPrevious keeps the currently installed file as <path>.prev, so it
+	// can be restored via RollbackProg.
+	KeepPrevious bool
+}
+
+// progMetadata is stored next to installed files as <path>.meta.
+type progMetadata struct {
+	URL        string     `json:"url"`
+	SHA256     string     `json:"sha256"`
+	Validators Validators `json:"validators"`
+}
+
+func metadataPath(path string) string {
+	return path + ".meta"
+}
+
+func readMetadata(path string) (*progMetadata, error) {
+	contents, err := ioutil.ReadFile(metadataPath(path))
+	if err != nil {
+		return nil, err
+	}
+	meta := &progMetadata{}
+	err = json.Unmarshal(contents, meta)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("parsing %s: %v", metadataPath(path), err)
 	}
-	return exe, nil
+	return meta, nil
 }
 
-func InstallProg(url, path string) error {
-	client := http.Client{
-		Transport: &http.Transport{
-			Dial: func(network, addr string) (net.Conn, error) {
-				return net.DialTimeout(network, addr, DialTimeout)
-			},
-		},
+func writeMetadata(path string, meta *progMetadata) error {
+	contents, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(metadataPath(path), contents, 0644)
+	if err != nil {
+		return fmt.Errorf("writing %s: %v", metadataPath(path), err)
 	}
-	resp, err := client.Get(url)
+	return nil
+}
+
+// IsUnchanged returns true if the file installed at path was downloaded from
+// url, and the remote file has not changed since.
+func IsUnchanged(url, path string) bool {
+	meta, err := readMetadata(path)
 	if err != nil {
-		return fmt.Errorf("creating get request for %s: %+v", url, err)
+		if !os.IsNotExist(err) {
+			klog.Warningf("reading metadata of %s: %v", path, err)
+		}
+		return false
+	}
+	if meta.URL != url || meta.Validators.IsEmpty() {
+		return false
+	}
+	// Make sure the file has not been replaced since, e.g. via a rollback.
+	digest, err := FileDigest(path)
+	if err != nil || digest != meta.SHA256 {
+		return false
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("downloading %s: got status code %d",
-			url, resp.StatusCode)
+	return !remoteModified(url, meta.Validators)
+}
+
+// ParseDigest extracts a hex encoded SHA-256 digest from the contents of a
+// digest file. Both a bare digest and the "<digest>  <filename>" format
+// produced by sha256sum are accepted.
+func ParseDigest(contents string) (string, error) {
+	fields := strings.Fields(contents)
+	if len(fields) < 1 {
+		return "", fmt.Errorf("empty digest")
+	}
+	digest := strings.ToLower(fields[0])
+	buf, err := hex.DecodeString(digest)
+	if err != nil || len(buf) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 digest %q", fields[0])
 	}
+	return digest, nil
+}
+
+// FetchDigest downloads the SHA-256 digest file at url. If the server reports
+// that the digest file does not exist, an empty digest is returned without
+// an error.
+func FetchDigest(url string) (string, error) {
+	contents, err := FetchSmallFile(url, 4096)
+	if err != nil || contents == nil {
+		return "", err
+	}
+	return ParseDigest(string(contents))
+}
+
+// FileDigest returns the hex encoded SHA-256 digest of the file at path.
+func FileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %+v", path, err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %+v", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// InstallProg downloads url and installs it at path. The download is verified
+// according to opts; if verification fails, the download is discarded and
+// path is left untouched.
+func InstallProg(url, path string, opts InstallOptions) error {
 	_ = os.MkdirAll(filepath.Dir(path), 0755)
 	tmpPath := path + ".part"
-	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE, 0755)
+	validators, err := downloadFile(url, tmpPath)
 	if err != nil {
-		return fmt.Errorf("opening %s for writing: %+v", path, err)
+		return err
 	}
-	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
+	digest, err := FileDigest(tmpPath)
 	if err != nil {
-		return fmt.Errorf("writing %s: %+v", path, err)
+		return err
+	}
+	if opts.Digest != "" {
+		if digest != strings.ToLower(opts.Digest) {
+			os.Remove(tmpPath)
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s",
+				url, opts.Digest, digest)
+		}
+		klog.V(2).Infof("verified SHA-256 digest of %s: %s", url, digest)
+	}
+	if opts.Verify != nil {
+		err = opts.Verify(tmpPath)
+		if err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("verifying %s: %w", url, err)
+		}
+	}
+	if opts.KeepPrevious {
+		err = keepPrevious(path)
+		if err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
 	}
 	err = os.Rename(tmpPath, path)
 	if err != nil {
 		return fmt.Errorf("renaming %s to %s: %+v", tmpPath, path, err)
 	}
 	klog.V(2).Infof("downloaded %s from %s", path, url)
+	err = writeMetadata(path, &progMetadata{
+		URL:        url,
+		SHA256:     digest,
+		Validators: validators,
+	})
+	if err != nil {
+		klog.Warningf("%v", err)
+	}
+	return nil
+}
+
+func previousPath(path string) string {
+	return path + ".prev"
+}
+
+func keepPrevious(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	prevPath := previousPath(path)
+	os.Remove(prevPath)
+	err := os.Link(path, prevPath)
+	if err != nil {
+		return fmt.Errorf("linking %s to %s: %+v", path, prevPath, err)
+	}
+	return nil
+}
+
+// HasPreviousProg returns true if there is a previous version of path that was
+// kept by InstallProg.
+func HasPreviousProg(path string) bool {
+	_, err := os.Stat(previousPath(path))
+	return err == nil
+}
+
+// RollbackProg restores the previous version of path kept by InstallProg.
+func RollbackProg(path string) error {
+	prevPath := previousPath(path)
+	err := os.Rename(prevPath, path)
+	if err != nil {
+		return fmt.Errorf("renaming %s to %s: %+v", prevPath, path, err)
+	}
+	klog.Warningf("rolled back %s to previous version", path)
+	return nil
+}
+
+// CommitProg removes the previous version of path kept by InstallProg.
+func CommitProg(path string) error {
+	prevPath := previousPath(path)
+	err := os.Remove(prevPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing %s: %+v", prevPath, err)
+	}
 	return nil
 }
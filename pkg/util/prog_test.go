@@ -0,0 +1,162 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDigest(t *testing.T) {
+	digest := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	testCases := []struct {
+		name     string
+		contents string
+		expected string
+		failure  bool
+	}{
+		{
+			name:     "bare digest",
+			contents: digest + "\n",
+			expected: digest,
+		},
+		{
+			name:     "sha256sum output",
+			contents: digest + "  itzo-latest\n",
+			expected: digest,
+		},
+		{
+			name:     "uppercase digest",
+			contents: "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08",
+			expected: digest,
+		},
+		{
+			name:     "empty",
+			contents: " \n",
+			failure:  true,
+		},
+		{
+			name:     "too short",
+			contents: "9f86d081",
+			failure:  true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := ParseDigest(tc.contents)
+			if tc.failure {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestInstallProgDigest(t *testing.T) {
+	content := []byte("#!/bin/sh\necho itzo\n")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write(content)
+		}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "itzo")
+
+	wrong := "0000000000000000000000000000000000000000000000000000000000000000"
+	err = InstallProg(server.URL+"/itzo", path, InstallOptions{Digest: wrong})
+	assert.Error(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	err = InstallProg(server.URL+"/itzo", path, InstallOptions{Digest: digest})
+	assert.NoError(t, err)
+	buf, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, buf)
+}
+
+func TestInstallProgRollback(t *testing.T) {
+	content := []byte("new version")
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write(content)
+		}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "itzo")
+	err = ioutil.WriteFile(path, []byte("old version"), 0755)
+	assert.NoError(t, err)
+
+	denylist := NewDenylist(filepath.Join(dir, "denylist"))
+	opts := InstallOptions{
+		Verify:       denylist.Check,
+		KeepPrevious: true,
+	}
+	err = InstallProg(server.URL+"/itzo", path, opts)
+	assert.NoError(t, err)
+	assert.True(t, HasPreviousProg(path))
+
+	assert.NoError(t, denylist.Add(path, "1.0.0"))
+	assert.NoError(t, RollbackProg(path))
+	assert.False(t, HasPreviousProg(path))
+	buf, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "old version", string(buf))
+
+	// The new version is on the denylist now.
+	err = InstallProg(server.URL+"/itzo", path, opts)
+	var deniedErr *DeniedError
+	assert.True(t, errors.As(err, &deniedErr))
+	assert.Equal(t, "1.0.0", deniedErr.Version)
+	buf, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "old version", string(buf))
+}
+
+func TestIsUnchanged(t *testing.T) {
+	content := []byte("itzo")
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write(content)
+		}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "itzo-launcher-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "itzo")
+	url := server.URL + "/itzo"
+
+	assert.False(t, IsUnchanged(url, path))
+	err = InstallProg(url, path, InstallOptions{})
+	assert.NoError(t, err)
+	assert.True(t, IsUnchanged(url, path))
+	assert.False(t, IsUnchanged(server.URL+"/other", path))
+
+	// The installed file has been replaced.
+	err = ioutil.WriteFile(path, []byte("something else"), 0755)
+	assert.NoError(t, err)
+	assert.False(t, IsUnchanged(url, path))
+}
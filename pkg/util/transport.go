@@ -0,0 +1,104 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// DownloadProxy is the URL of the proxy used for downloads. If it's
+	// empty, the proxy is configured via HTTPS_PROXY, HTTP_PROXY and
+	// NO_PROXY.
+	DownloadProxy = ""
+	// DownloadCABundle holds additional CA certificates trusted for
+	// downloads, either as a path to a PEM file or as PEM data.
+	DownloadCABundle = ""
+	// DownloadClientCert and DownloadClientKey are the client certificate and
+	// key for downloads from servers requiring mutual TLS, either as paths to
+	// PEM files or as PEM data.
+	DownloadClientCert = ""
+	DownloadClientKey  = ""
+)
+
+const pemPrefix = "-----BEGIN"
+
+// readPEM returns PEM data that's either given inline, or stored in a file.
+func readPEM(pathOrData string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(pathOrData), pemPrefix) {
+		return []byte(pathOrData), nil
+	}
+	contents, err := ioutil.ReadFile(pathOrData)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", pathOrData, err)
+	}
+	return contents, nil
+}
+
+func newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if DownloadCABundle != "" {
+		pem, err := readPEM(DownloadCABundle)
+		if err != nil {
+			return nil, fmt.Errorf("loading CA bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle")
+		}
+		config.RootCAs = pool
+	}
+	if DownloadClientCert != "" || DownloadClientKey != "" {
+		if DownloadClientCert == "" || DownloadClientKey == "" {
+			return nil, fmt.Errorf("both a client certificate and key are needed")
+		}
+		certPEM, err := readPEM(DownloadClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		keyPEM, err := readPEM(DownloadClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client key: %v", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func newHTTPClient() (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if DownloadProxy != "" {
+		proxyURL, err := url.Parse(DownloadProxy)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy URL %q: %v", DownloadProxy, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: proxy,
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, DialTimeout)
+			},
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}, nil
+}
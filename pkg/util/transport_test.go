@@ -0,0 +1,57 @@
+package util
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadCABundle(t *testing.T) {
+	DownloadRetries = 0
+	defer func() {
+		DownloadRetries = 5
+		DownloadCABundle = ""
+	}()
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("itzo"))
+		}))
+	defer server.Close()
+
+	_, err := FetchSmallFile(server.URL+"/itzo", 4096)
+	assert.Error(t, err)
+
+	DownloadCABundle = string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}))
+	contents, err := FetchSmallFile(server.URL+"/itzo", 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, "itzo", string(contents))
+
+	DownloadCABundle = "not a PEM file"
+	_, err = FetchSmallFile(server.URL+"/itzo", 4096)
+	assert.Error(t, err)
+}
+
+func TestDownloadProxy(t *testing.T) {
+	defer func() {
+		DownloadProxy = ""
+	}()
+	proxied := ""
+	proxy := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			proxied = r.URL.String()
+			w.Write([]byte("itzo"))
+		}))
+	defer proxy.Close()
+
+	DownloadProxy = proxy.URL
+	contents, err := FetchSmallFile("http://itzo.example.invalid/itzo-latest", 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, "itzo", string(contents))
+	assert.Equal(t, "http://itzo.example.invalid/itzo-latest", proxied)
+}